@@ -21,143 +21,2379 @@
 package cli // import "github.com/qqiao/cli"
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
 	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"syscall"
 	"text/template"
+	"time"
 )
 
+// ErrorHandling defines how the set of command line flags of a component
+// behaves if the parsing fails
+type ErrorHandling int
+
+const (
+	// ExitOnError exits the program with status 2 on parse errors. This is
+	// the default
+	ExitOnError ErrorHandling = iota
+
+	// ContinueOnError returns the parse errors, which Execute then returns
+	ContinueOnError
+
+	// PanicOnError panics on parse errors
+	PanicOnError
+)
+
+// flagErrorHandling maps ErrorHandling to its counterpart in the flag package
+var flagErrorHandling = map[ErrorHandling]flag.ErrorHandling{
+	ExitOnError:     flag.ExitOnError,
+	ContinueOnError: flag.ContinueOnError,
+	PanicOnError:    flag.PanicOnError,
+}
+
+// Stage is a stage reached by Execute while dispatching
+type Stage int
+
+const (
+	// StageParsed is reached once the flags of the dispatching component
+	// have been parsed
+	StageParsed Stage = iota
+
+	// StageMatched is reached once a sub-component has been matched
+	StageMatched
+
+	// StageRun is reached right before the matched sub-component is run
+	StageRun
+
+	// StageDone is reached once the matched sub-component has returned
+	StageDone
+)
+
+// stageNames are the names of the stages, as returned by String
+var stageNames = map[Stage]string{
+	StageParsed:  "Parsed",
+	StageMatched: "Matched",
+	StageRun:     "Run",
+	StageDone:    "Done",
+}
+
+func (s Stage) String() string {
+	if name, ok := stageNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("Stage(%d)", int(s))
+}
+
+// Event describes a stage reached by Execute while dispatching
+type Event struct {
+	// Stage is the stage reached
+	Stage Stage
+
+	// Component is the dispatching component for StageParsed, and the
+	// matched sub-component for all other stages
+	Component *Component
+
+	// Args are the arguments of Component at the stage
+	Args []string
+}
+
 // Component represents a command line component
 type Component struct {
-	// Components are the sub-components of the current component
+	// Components are the sub-components of the current component.
+	// Execute dispatches through a component with sub-components to them,
+	// without calling its Run
 	Components []*Component
 
 	// Run runs the component
 	// args are the arguments after the component name
 	Run func(ctx context.Context, comp *Component, args []string)
 
+	// Args, if set, validates the positional arguments of the component.
+	// Before running the component, Execute parses its flags with Parse
+	// and calls Args with the remaining arguments, returning the error
+	// without running the component if they are invalid. As its flags are
+	// then parsed, Run only receives the positional arguments
+	Args func(c *Component, args []string) error
+
+	// Precondition, if set, is checked by Execute before running the
+	// component or dispatching through it, e.g. for the operating system or
+	// build features it requires. If it returns an error, Execute returns it
+	// without running the component
+	Precondition func() error
+
+	// PreRun, if set, is run by Execute before the Run of any leaf
+	// component dispatched to through this component, or of this component
+	// itself. The hooks of all the components on the path to the leaf are
+	// run from the root down, each with the leaf and the same args as its
+	// Run. If a hook returns an error, Execute returns it without running
+	// the leaf or the remaining PreRun hooks, but still runs the PostRun
+	// hooks
+	PreRun func(ctx context.Context, comp *Component, args []string) error
+
+	// PostRun, if set, is run by Execute after the Run of any leaf
+	// component dispatched to through this component, or of this component
+	// itself. The hooks are run in the reverse order of PreRun, each with
+	// the leaf and the same args as its Run. All the hooks are run even if
+	// one of them or a PreRun hook fails, and the first error is returned
+	PostRun func(ctx context.Context, comp *Component, args []string) error
+
+	// Fallback, if set, is invoked by Passthrough when no runnable
+	// sub-component matches the name given on the command line.
+	// name is the unmatched name and args are the arguments after it
+	Fallback func(ctx context.Context, comp *Component, name string, args []string)
+
+	// OnEvent, if set, is called for each stage reached by Execute while
+	// dispatching from this component or from any of its descendants, so
+	// that the OnEvent of the root follows nested commands from the root
+	// down to the leaf
+	OnEvent func(Event)
+
+	// Router, if set on the root component, replaces the matching of names
+	// by Execute. It is called with the dispatching component and its
+	// arguments after its flags, and returns the component to dispatch to
+	// as if it were a sub-component, with its arguments. If Router returns
+	// a nil component and no error, the names are matched as usual
+	Router func(c *Component, args []string) (*Component, []string, error)
+
+	// OnResolved, if set on the root component, is called once Execute has
+	// resolved the leaf component to run, with the leaf and its arguments,
+	// right before running it. Components with sub-components are not
+	// leaves, as they dispatch further
+	OnResolved func(comp *Component, args []string)
+
 	// UsageLine is the one-line usage message.
 	// The first word in the line is taken to be the component name
 	UsageLine string
 
+	// Aliases are alternative names the component can be dispatched by.
+	// Should siblings share an alias, the first one in Components is used
+	Aliases []string
+
+	// ArgNames are the names of the positional arguments of the component.
+	// They are appended to the UsageLine in the usage information, and
+	// used to report missing arguments
+	ArgNames []string
+
 	// Short is the short description of the component
 	Short string
 
 	// Long is the longer more detailed description of the component
 	Long string
 
+	// EnabledFunc, if set, reports whether the component is enabled.
+	// Disabled components are neither shown in the usage information nor
+	// dispatched to
+	EnabledFunc func() bool
+
+	// EnableConfigFlag adds a persistent -config flag to the component,
+	// typically the root. When it is given, the JSON configuration file it
+	// names, in the form read by ApplyDefaultsFromConfig, sets the flags of
+	// each component Execute parses from there down to the leaf it resolves.
+	// Flags given on the command line override the configuration, and the
+	// flags a component does not define are left to the others. The
+	// defaultCommand of the configuration only applies to this component
+	EnableConfigFlag bool
+
+	// EnablePrintCommand adds a -print-command flag to the root component.
+	// When it is set, Execute prints the path of the component it resolves
+	// with its arguments and the effective values of its flags, to the
+	// output in the context, instead of running it
+	EnablePrintCommand bool
+
+	// VerboseComponentList lists the sub-components in the usage
+	// information by their UsageLine instead of their name and Short
+	// description
+	VerboseComponentList bool
+
+	// Experimental marks the component as experimental. Passthrough only
+	// dispatches to experimental components when the environment variable
+	// named after the root component, e.g. APP_EXPERIMENTAL for app, is set
+	// to 1, so a single variable enables them throughout the application.
+	// Characters other than letters and digits in the name are replaced by
+	// underscores, e.g. MY_APP_EXPERIMENTAL for my-app
+	Experimental bool
+
+	// HideComponentsInUsage omits the sub-components from the usage
+	// information. The sub-components can still be dispatched to
+	HideComponentsInUsage bool
+
+	// Group is the heading the component is listed under in the usage
+	// information of its parent, e.g. "Management Commands". Components
+	// without a Group are listed under the default heading
+	Group string
+
+	// UsageTemplate, if set, is the text/template rendered by Usage instead
+	// of the default. It receives the component as "component", its
+	// rendered flags as "flags" and its examples as "examples", and can use
+	// the same funcs as the default, including the colors bold, cyan and dim
+	UsageTemplate string
+
+	// UsageFunc, if set, replaces the rendering of the usage information by
+	// Usage entirely, including on errors parsing the flags. An error it
+	// returns is printed to the output
+	UsageFunc func(c *Component) error
+
+	// ForceColor colors the usage information of the component and its
+	// descendants even if the output is not a terminal or NO_COLOR is set
+	ForceColor bool
+
+	// DisableColor never colors the usage information of the component and
+	// its descendants, which is otherwise colored if the output is a
+	// terminal and NO_COLOR is not set. It takes precedence over ForceColor
+	DisableColor bool
+
+	// SortComponents lists the sub-components in the usage information
+	// sorted by name instead of in the order they are declared
+	SortComponents bool
+
+	// CompactFlags renders the flags in the usage information in two
+	// aligned columns, names on the left and descriptions wrapped on the
+	// right, instead of the layout of flag.PrintDefaults
+	CompactFlags bool
+
+	// FlagIndent is the number of spaces the flags are indented by when
+	// rendered with CompactFlags. Zero means the default of 2
+	FlagIndent int
+
+	// ErrorHandling defines how the set of command line flags of the
+	// component behaves if the parsing fails
+	ErrorHandling ErrorHandling
+
+	// AllowFlagAbbreviation accepts unambiguous prefixes of the names of
+	// flags on the command line, e.g. -verb for -verbose
+	AllowFlagAbbreviation bool
+
+	// parent is the component this component was dispatched from
+	parent *Component
+
+	// output is the destination for usage messages specific to this
+	// component, overriding the one set on the tree with SetOutput
+	output io.Writer
+
 	// flagSet is a set of flags specific to this component
 	flagSet *flag.FlagSet
+
+	// persistentFlagSet is a set of flags inherited by all the
+	// descendants of this component
+	persistentFlagSet *flag.FlagSet
+
+	// childFlagSet is a set of flags inherited by the direct
+	// sub-components of this component
+	childFlagSet *flag.FlagSet
+
+	// flagValidators are the validators of flag values, keyed by flag name
+	flagValidators map[string][]func(string) error
+
+	// defaultCommand is the name of the sub-component Passthrough
+	// dispatches to when no name is given on the command line
+	defaultCommand string
+
+	// globalOnlyFlags are the flags that must be given before the name of
+	// a sub-component
+	globalOnlyFlags []string
+
+	// flagNormalizers are the normalizers of flag values, keyed by flag name
+	flagNormalizers map[string][]func(string) string
+
+	// flagFiles are the flags whose values are read from the files named
+	// by other flags, keyed by the name of the flag naming the file
+	flagFiles map[string]string
+
+	// flagEnvs are the environment variables bound to flags, keyed by flag
+	// name
+	flagEnvs map[string]string
+
+	// flagDefaultFuncs compute the values of unset flags, keyed by flag
+	// name
+	flagDefaultFuncs map[string]func(*Component) string
+
+	// flagRequires are the flags required by each flag, keyed by flag name
+	flagRequires map[string][]string
+
+	// examples are the example invocations listed in the usage information
+	examples []Example
+}
+
+// Example is an example invocation of a component
+type Example struct {
+	// Command is the command line of the invocation
+	Command string
+
+	// Description describes what the invocation does
+	Description string
+}
+
+// AddExample adds an example invocation of the component, listed in the
+// Examples section of the usage information in the order they are added
+func (c *Component) AddExample(command, description string) {
+	c.examples = append(c.examples, Example{command, description})
+}
+
+// AddFlagValidator adds a function validating the value of the named flag.
+// Validators are run by Parse after the command line has been parsed
+func (c *Component) AddFlagValidator(name string, fn func(value string) error) {
+	if nil == c.flagValidators {
+		c.flagValidators = make(map[string][]func(string) error)
+	}
+	c.flagValidators[name] = append(c.flagValidators[name], fn)
+}
+
+// AddFlagNormalizer adds a function normalizing the value of the named flag.
+// Parse replaces the value of the flag, when set on the command line, with
+// the result of fn before checking and validating it
+func (c *Component) AddFlagNormalizer(name string, fn func(string) string) {
+	if nil == c.flagNormalizers {
+		c.flagNormalizers = make(map[string][]func(string) string)
+	}
+	c.flagNormalizers[name] = append(c.flagNormalizers[name], fn)
+}
+
+// BindFlagFromFile binds the targetFlag to the file named by fileFlag: when
+// fileFlag is set on the command line, Parse sets targetFlag to the contents
+// of the file, with leading and trailing white space removed
+func (c *Component) BindFlagFromFile(targetFlag, fileFlag string) {
+	if nil == c.flagFiles {
+		c.flagFiles = make(map[string]string)
+	}
+	c.flagFiles[fileFlag] = targetFlag
+}
+
+// BindFlagEnv binds the named flag to the environment variable env: when the
+// flag is not set on the command line, Parse sets it to the value of env if
+// env is set. The flag on the command line thus takes precedence over env,
+// which takes precedence over the default
+func (c *Component) BindFlagEnv(name, env string) {
+	if nil == c.flagEnvs {
+		c.flagEnvs = make(map[string]string)
+	}
+	c.flagEnvs[name] = env
+}
+
+// AddFlagDefaultFunc sets a function computing the value of the named flag
+// when it is not set on the command line. Parse calls fn once the other
+// flags have been parsed, so it can derive the value from them
+func (c *Component) AddFlagDefaultFunc(name string, fn func(c *Component) string) {
+	if nil == c.flagDefaultFuncs {
+		c.flagDefaultFuncs = make(map[string]func(*Component) string)
+	}
+	c.flagDefaultFuncs[name] = fn
+}
+
+// MarkFlagRequires marks the named flag as requiring the other flags: if
+// flag is set on the command line, each of requires must also be set.
+// The requirements are checked by Parse
+func (c *Component) MarkFlagRequires(flag string, requires ...string) {
+	if nil == c.flagRequires {
+		c.flagRequires = make(map[string][]string)
+	}
+	c.flagRequires[flag] = append(c.flagRequires[flag], requires...)
+}
+
+// MarkFlagGlobalOnly marks the named flag as only allowed before the name of
+// the sub-component. Passthrough reports an error if the flag is given in the
+// arguments of the matched sub-component instead
+func (c *Component) MarkFlagGlobalOnly(name string) {
+	c.globalOnlyFlags = append(c.globalOnlyFlags, name)
 }
 
 // FlagSet returns the set of command line flags
 func (c *Component) FlagSet() *flag.FlagSet {
 	if nil == c.flagSet {
-		c.flagSet = flag.NewFlagSet(c.Name(), flag.ExitOnError)
+		c.flagSet = flag.NewFlagSet(c.Name(),
+			flagErrorHandling[c.ErrorHandling])
 		c.flagSet.Usage = c.Usage
 	}
 
+	// The flags are added when they are first needed, so EnableConfigFlag
+	// and EnablePrintCommand can be set after the flags have been created
+	if c.EnableConfigFlag && nil == c.flagSet.Lookup(configFlag) {
+		if nil == c.PersistentFlagSet().Lookup(configFlag) {
+			c.persistentFlagSet.String(configFlag, "", "read flag defaults "+
+				"from the JSON configuration file at `path`")
+		}
+		f := c.persistentFlagSet.Lookup(configFlag)
+		c.flagSet.Var(f.Value, f.Name, f.Usage)
+	}
+	if c.EnablePrintCommand && nil == c.flagSet.Lookup(printCommandFlag) {
+		c.flagSet.Bool(printCommandFlag, false,
+			"print the resolved command and flags instead of running it")
+	}
+
 	return c.flagSet
 }
 
-// Name returns the name of the component: the first word in the UsageLine
-func (c *Component) Name() string {
-	name := c.UsageLine
-	i := strings.Index(name, " ")
-	if i >= 0 {
-		name = name[:i]
+// StringMap defines a flag with specified name and usage string in the set
+// of command line flags of the component. The flag can be repeated, each
+// occurrence in the form key=value adding an entry to the map returned
+func (c *Component) StringMap(name, usage string) *map[string]string {
+	m := make(map[string]string)
+	c.FlagSet().Var((*stringMapValue)(&m), name, usage)
+	return &m
+}
+
+// stringMapValue is a flag.Value accumulating key=value pairs into a map
+type stringMapValue map[string]string
+
+func (v *stringMapValue) String() string {
+	if nil == v || 0 == len(*v) {
+		return ""
 	}
-	return name
+
+	pairs := make([]string, 0, len(*v))
+	for key, value := range *v {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
 }
 
-// Runnable returns whether this component is runnable or pure informational
-func (c *Component) Runnable() bool {
-	return nil != c.Run
+func (v *stringMapValue) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i < 0 {
+		return fmt.Errorf("%q is not in the form key=value", s)
+	}
+	(*v)[s[:i]] = s[i+1:]
+	return nil
 }
 
-// SetOutput sets the destination for usage messages.
-// If output is nil, stderr is used
-func (c *Component) SetOutput(output io.Writer) {
-	c.FlagSet().SetOutput(output)
+// PersistentFlagSet returns the set of command line flags accepted by the
+// component and inherited by all of its descendants. Execute adds the flags
+// to the FlagSet of the component and of each sub-component it dispatches
+// to, so that a flag defined on the root can be given at any level.
+//
+// A flag defined by a component itself takes precedence over a persistent
+// flag of the same name, as does a persistent flag of a nearer ancestor
+func (c *Component) PersistentFlagSet() *flag.FlagSet {
+	if nil == c.persistentFlagSet {
+		c.persistentFlagSet = flag.NewFlagSet(c.Name(), flag.ContinueOnError)
+	}
 
-	for _, c := range c.Components {
-		c.SetOutput(output)
+	return c.persistentFlagSet
+}
+
+// inheritPersistentFlags adds the persistent flags of the component and of
+// its ancestors to its set of command line flags
+func (c *Component) inheritPersistentFlags() {
+	for ancestor := c; nil != ancestor; ancestor = ancestor.parent {
+		c.inheritFlags(ancestor.persistentFlagSet)
 	}
 }
 
-var usageTemplate = `
-{{- if .component.Runnable -}}
-Usage: {{.component.UsageLine}}
-{{end}}
-{{- if ne (len .component.Long) 0 -}}
-{{.component.Long | trim}}
-{{end}}
-{{- if ne (len .component.Components) 0}}
-The components are:
-{{- range .component.Components}}
-{{- if .Runnable}}
-  {{.Name | printf "%-11s"}} {{.Short -}}
-{{end -}}
-{{end}}
-{{end}}
-{{- if ne (len .flags) 0}}
-The flags are:
-{{.flags -}}
-{{end}}`
+// ChildFlagSet returns the set of command line flags inherited by the direct
+// sub-components of the component, but not by their descendants. The flags
+// are added to the FlagSet of the sub-component matched by Passthrough,
+// unless it defines a flag with the same name itself
+func (c *Component) ChildFlagSet() *flag.FlagSet {
+	if nil == c.childFlagSet {
+		c.childFlagSet = flag.NewFlagSet(c.Name(), flag.ContinueOnError)
+	}
 
-// Usage prints out the usage information
-func (c *Component) Usage() {
-	flagSet := c.FlagSet()
-	output := flagSet.Output()
+	return c.childFlagSet
+}
 
-	// Capture the output of the flagset so that it can be merged with the rest
-	// of the message
-	var buf bytes.Buffer
-	flagSet.SetOutput(&buf)
-	flagSet.PrintDefaults()
+// link links the component to parent, adding the flags it inherits from the
+// ChildFlagSet of parent and from the PersistentFlagSet of its ancestors to
+// its set of command line flags, as when parent dispatches to it
+func (c *Component) link(parent *Component) {
+	c.parent = parent
+	c.inheritFlags(parent.childFlagSet)
+	c.inheritPersistentFlags()
+}
 
-	flagSet.SetOutput(output)
+// inheritFlags adds the flags in flags to the set of command line flags of
+// the component, skipping those already defined
+func (c *Component) inheritFlags(flags *flag.FlagSet) {
+	if nil == flags {
+		return
+	}
 
-	tmpl(output, usageTemplate, map[string]interface{}{
-		"component": c,
-		"flags":     buf.String(),
+	flagSet := c.FlagSet()
+	flags.VisitAll(func(f *flag.Flag) {
+		if nil == flagSet.Lookup(f.Name) {
+			flagSet.Var(f.Value, f.Name, f.Usage)
+			flagSet.Lookup(f.Name).DefValue = f.DefValue
+		}
 	})
 }
 
-// Passthrough is a implementation of the Run function that passes the
-// execution through the sub commands
-func Passthrough(ctx context.Context, comp *Component, args []string) {
-	flagSet := comp.FlagSet()
+// Parse parses args with the set of command line flags of the component,
+// then post-processes the flags, returning the first error encountered.
+// Abbreviated flag names are expanded first if AllowFlagAbbreviation is
+// set. In order, the configuration named by -config is applied, the unset
+// flags bound to environment variables are read, the flag values are
+// normalized, the flags bound to files are read, the unset flags with
+// default functions are computed, and the flag requirements and validators
+// are checked.
+//
+// Given a -help-short flag, Parse prints the short usage information with
+// UsageShort and returns ErrHelp
+func (c *Component) Parse(args []string) error {
+	return cause(c.parse(args))
+}
 
-	if flag.ErrHelp == flagSet.Parse(args) {
-		return
+// parse implements Parse for Execute: the errors of the flag package are
+// reportedErrors, as the flag package reports them itself, and the other
+// errors are usageErrors
+func (c *Component) parse(args []string) error {
+	args, err := c.expandFlags(args)
+	if nil != err {
+		return usageError{err}
+	}
+	if hasFlag(c.FlagSet(), args, helpShortFlag) {
+		c.UsageShort()
+		return ErrHelp
 	}
 
-	if flagSet.NArg() < 1 {
-		flagSet.Usage()
-		return
+	// The flag package reports its own errors, as they happen, and prints
+	// the usage information when help is requested
+	if err := c.FlagSet().Parse(args); nil != err {
+		if ErrHelp == err {
+			return err
+		}
+		return reportedError{err}
+	}
+	if err := c.postParse(); nil != err {
+		return usageError{err}
+	}
+	return nil
+}
+
+// expandFlags expands the abbreviated flag names among the flags at the start
+// of args to their full names if AllowFlagAbbreviation is set. Names
+// matching no flag are left for the flag package to report
+func (c *Component) expandFlags(args []string) ([]string, error) {
+	if !c.AllowFlagAbbreviation {
+		return args, nil
 	}
 
-	name := flagSet.Arg(0)
+	flagSet := c.FlagSet()
+	expanded := append([]string(nil), args...)
+	for i := 0; i < len(expanded); i++ {
+		arg := expanded[i]
+		if "--" == arg || len(arg) < 2 || '-' != arg[0] {
+			break
+		}
 
-	for _, c := range comp.Components {
-		if name == c.Name() {
-			if c.Runnable() {
-				c.Run(ctx, c, flagSet.Args()[1:])
-				return
+		dashes := "-"
+		if strings.HasPrefix(arg, "--") {
+			dashes = "--"
+		}
+		name, value := strings.TrimPrefix(arg, dashes), ""
+		if j := strings.Index(name, "="); j >= 0 {
+			name, value = name[:j], name[j:]
+		}
+
+		f := flagSet.Lookup(name)
+		if nil == f && "help" != name && "h" != name {
+			var matches []*flag.Flag
+			flagSet.VisitAll(func(f *flag.Flag) {
+				if strings.HasPrefix(f.Name, name) {
+					matches = append(matches, f)
+				}
+			})
+			if len(matches) > 1 {
+				names := make([]string, len(matches))
+				for j, m := range matches {
+					names[j] = "-" + m.Name
+				}
+				return nil, fmt.Errorf("ambiguous flag -%s: could be %s", name,
+					strings.Join(names, ", "))
 			}
+			if 1 == len(matches) {
+				f = matches[0]
+				expanded[i] = dashes + f.Name + value
+			}
+		}
+
+		if nil == f || "" != value {
+			continue
+		}
+		if !isBoolFlag(f) {
+			i++
 		}
 	}
-	flagSet.Usage()
+	return expanded, nil
 }
 
-func tmpl(w io.Writer, text string, data interface{}) {
-	t := template.New("top")
-	t.Funcs(template.FuncMap{
-		"trim": strings.TrimSpace,
+// postParse post-processes the flags once parsed, as described in Parse
+func (c *Component) postParse() error {
+	for _, step := range []func() error{
+		c.loadConfigFlag,
+		c.applyFlagEnvs,
+		c.normalizeFlags,
+		c.readFlagFiles,
+		c.applyFlagDefaultFuncs,
+		c.checkFlagRequires,
+		c.validateFlags,
+	} {
+		if err := step(); nil != err {
+			return err
+		}
+	}
+	return nil
+}
+
+// configFlag is the name of the flag added by EnableConfigFlag
+const configFlag = "config"
+
+// loadConfigFlag applies the configuration named by the -config flag of the
+// nearest component with EnableConfigFlag on the path to the component, if
+// given, to the flags of the component
+func (c *Component) loadConfigFlag() error {
+	path := ""
+	for p := c; nil != p; p = p.parent {
+		if !p.EnableConfigFlag {
+			continue
+		}
+		p.FlagSet()
+		if f := p.PersistentFlagSet().Lookup(configFlag); nil != f {
+			path = f.Value.String()
+		}
+		break
+	}
+	if "" == path {
+		return nil
+	}
+
+	file, err := os.Open(path)
+	if nil != err {
+		return err
+	}
+	defer file.Close()
+	defaultCommand, flags, err := readConfig(file)
+	if nil != err {
+		return err
+	}
+
+	// The flags given on the command line of any component on the path are
+	// kept, as the inherited flags share their values with the ancestors
+	set := make(map[flag.Value]bool)
+	for _, p := range c.path() {
+		p.FlagSet().Visit(func(f *flag.Flag) {
+			set[f.Value] = true
+		})
+	}
+	for name, value := range flags {
+		f := c.FlagSet().Lookup(name)
+		if nil == f || set[f.Value] {
+			continue
+		}
+		if err := c.setDefault(name, fmt.Sprint(value)); nil != err {
+			return err
+		}
+	}
+	if c.EnableConfigFlag {
+		c.defaultCommand = defaultCommand
+	}
+	return nil
+}
+
+func (c *Component) applyFlagEnvs() error {
+	set := c.setFlags()
+
+	var err error
+	c.FlagSet().VisitAll(func(f *flag.Flag) {
+		env, ok := c.flagEnvs[f.Name]
+		if !ok || set[f.Name] || nil != err {
+			return
+		}
+		if value, ok := os.LookupEnv(env); ok {
+			if err = c.flagSet.Set(f.Name, value); nil != err {
+				err = fmt.Errorf("invalid value %q for flag -%s from %s: %v",
+					value, f.Name, env, err)
+			}
+		}
+	})
+	return err
+}
+
+func (c *Component) normalizeFlags() error {
+	var err error
+	c.FlagSet().Visit(func(f *flag.Flag) {
+		for _, fn := range c.flagNormalizers[f.Name] {
+			if nil == err {
+				err = c.flagSet.Set(f.Name, fn(f.Value.String()))
+			}
+		}
+	})
+	return err
+}
+
+func (c *Component) readFlagFiles() error {
+	var err error
+	c.FlagSet().Visit(func(f *flag.Flag) {
+		target, ok := c.flagFiles[f.Name]
+		if !ok || nil != err {
+			return
+		}
+		var b []byte
+		if b, err = ioutil.ReadFile(f.Value.String()); nil == err {
+			err = c.flagSet.Set(target, strings.TrimSpace(string(b)))
+		}
+	})
+	return err
+}
+
+func (c *Component) applyFlagDefaultFuncs() error {
+	set := c.setFlags()
+
+	var err error
+	c.FlagSet().VisitAll(func(f *flag.Flag) {
+		fn, ok := c.flagDefaultFuncs[f.Name]
+		if ok && !set[f.Name] && nil == err {
+			err = f.Value.Set(fn(c))
+		}
+	})
+	return err
+}
+
+func (c *Component) checkFlagRequires() error {
+	set := c.setFlags()
+
+	var err error
+	c.FlagSet().Visit(func(f *flag.Flag) {
+		for _, name := range c.flagRequires[f.Name] {
+			if nil == err && !set[name] {
+				err = fmt.Errorf("flag -%s requires -%s", f.Name, name)
+			}
+		}
+	})
+	return err
+}
+
+func (c *Component) validateFlags() error {
+	var err error
+	c.FlagSet().VisitAll(func(f *flag.Flag) {
+		for _, fn := range c.flagValidators[f.Name] {
+			if nil == err {
+				err = fn(f.Value.String())
+			}
+		}
+	})
+	return err
+}
+
+// FlagsAsEnv returns the values of the flags of the component in the form
+// PREFIX_NAME=value, suitable for the environment of a subprocess, e.g.
+// exec.Cmd.Env. Names are upper-cased with dashes replaced by underscores
+func (c *Component) FlagsAsEnv(prefix string) []string {
+	var env []string
+	c.FlagSet().VisitAll(func(f *flag.Flag) {
+		name := strings.ToUpper(strings.Replace(f.Name, "-", "_", -1))
+		if "" != prefix {
+			name = prefix + "_" + name
+		}
+		env = append(env, name+"="+f.Value.String())
+	})
+	return env
+}
+
+// setFlags returns the names of the flags that have been set
+func (c *Component) setFlags() map[string]bool {
+	set := make(map[string]bool)
+	c.FlagSet().Visit(func(f *flag.Flag) {
+		set[f.Name] = true
 	})
-	template.Must(t.Parse(text))
-	t.Execute(w, data)
+	return set
+}
+
+// programName returns the base name of the running binary. It is a variable
+// so that tests can substitute it
+var programName = func() string {
+	return filepath.Base(os.Args[0])
+}
+
+// LongFromFile sets Long to the contents of the file at path in fsys, e.g. an
+// embed.FS, so that long descriptions can be kept out of the source
+func (c *Component) LongFromFile(fsys fs.FS, path string) error {
+	b, err := fs.ReadFile(fsys, path)
+	if nil != err {
+		return err
+	}
+	c.Long = string(b)
+	return nil
+}
+
+// Name returns the name of the component: the first word in the UsageLine.
+// If UsageLine is empty, the base name of the running binary is used
+func (c *Component) Name() string {
+	if "" == c.UsageLine {
+		return programName()
+	}
+
+	name := c.UsageLine
+	i := strings.Index(name, " ")
+	if i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+// Parent returns the component this component was dispatched from by
+// Execute, or nil if it is the root or has not been dispatched to
+func (c *Component) Parent() *Component {
+	return c.parent
+}
+
+// Root returns the root of the component tree, following Parent
+func (c *Component) Root() *Component {
+	root := c
+	for nil != root.parent {
+		root = root.parent
+	}
+	return root
+}
+
+// FullName returns the names of the ancestors of the component and of the
+// component itself, separated by spaces, e.g. "tool remote add"
+func (c *Component) FullName() string {
+	if nil == c.parent {
+		return c.Name()
+	}
+	return c.parent.FullName() + " " + c.Name()
+}
+
+// HasName returns whether name is the name or one of the aliases of the
+// component
+func (c *Component) HasName(name string) bool {
+	if name == c.Name() {
+		return true
+	}
+	for _, alias := range c.Aliases {
+		if name == alias {
+			return true
+		}
+	}
+	return false
+}
+
+// Merge grafts the sub-components of other into the component, so that
+// separately defined trees can be combined. It returns an error without
+// merging anything if the name or an alias of a sub-component of other is
+// already the name or an alias of a sub-component of the component
+func (c *Component) Merge(other *Component) error {
+	for _, sub := range other.Components {
+		for _, name := range append([]string{sub.Name()}, sub.Aliases...) {
+			for _, existing := range c.Components {
+				if existing.HasName(name) {
+					return fmt.Errorf("cannot merge %s into %s: %s already "+
+						"names a component", other.Name(), c.Name(), name)
+				}
+			}
+		}
+	}
+
+	c.Components = append(c.Components, other.Components...)
+	return nil
+}
+
+// Runnable returns whether this component is runnable or pure informational
+func (c *Component) Runnable() bool {
+	return nil != c.Run
+}
+
+// Enabled returns whether this component is enabled
+func (c *Component) Enabled() bool {
+	return nil == c.EnabledFunc || c.EnabledFunc()
+}
+
+// SetOutput sets the destination for usage messages.
+// If output is nil, stderr is used
+//
+// Components with their own output set by SetComponentOutput keep it
+func (c *Component) SetOutput(output io.Writer) {
+	if nil == c.output {
+		c.FlagSet().SetOutput(output)
+	}
+
+	for _, c := range c.Components {
+		c.SetOutput(output)
+	}
+}
+
+// CheckArgs checks that args contains all the positional arguments named in
+// ArgNames, reporting the first one missing
+func (c *Component) CheckArgs(args []string) error {
+	if len(args) < len(c.ArgNames) {
+		return fmt.Errorf("missing argument: %s", c.ArgNames[len(args)])
+	}
+	return nil
+}
+
+// SetComponentOutput sets the destination for usage messages of this
+// component only. Unlike SetOutput, it is not inherited by the
+// sub-components, nor overridden by SetOutput on an ancestor
+func (c *Component) SetComponentOutput(output io.Writer) {
+	c.output = output
+	c.FlagSet().SetOutput(output)
+}
+
+// ExactArgs returns an Args validator accepting exactly n arguments
+func ExactArgs(n int) func(c *Component, args []string) error {
+	return RangeArgs(n, n)
+}
+
+// MinimumArgs returns an Args validator accepting at least n arguments
+func MinimumArgs(n int) func(c *Component, args []string) error {
+	return func(c *Component, args []string) error {
+		if len(args) < n {
+			return fmt.Errorf("%s requires at least %d arg(s), received %d",
+				c.Name(), n, len(args))
+		}
+		return nil
+	}
+}
+
+// MaximumArgs returns an Args validator accepting at most n arguments
+func MaximumArgs(n int) func(c *Component, args []string) error {
+	return func(c *Component, args []string) error {
+		if len(args) > n {
+			return fmt.Errorf("%s accepts at most %d arg(s), received %d",
+				c.Name(), n, len(args))
+		}
+		return nil
+	}
+}
+
+// RangeArgs returns an Args validator accepting between min and max
+// arguments, inclusive
+func RangeArgs(min, max int) func(c *Component, args []string) error {
+	return func(c *Component, args []string) error {
+		if len(args) < min || len(args) > max {
+			if min == max {
+				return fmt.Errorf("%s accepts %d arg(s), received %d",
+					c.Name(), min, len(args))
+			}
+			return fmt.Errorf("%s accepts between %d and %d arg(s), received %d",
+				c.Name(), min, max, len(args))
+		}
+		return nil
+	}
+}
+
+// ArgInt parses the i-th positional argument in args as an int
+func ArgInt(args []string, i int) (int, error) {
+	arg, err := argAt(args, i)
+	if nil != err {
+		return 0, err
+	}
+	v, err := strconv.Atoi(arg)
+	if nil != err {
+		return 0, fmt.Errorf("argument %d: invalid integer %q", i+1, arg)
+	}
+	return v, nil
+}
+
+// ArgDuration parses the i-th positional argument in args as a
+// time.Duration
+func ArgDuration(args []string, i int) (time.Duration, error) {
+	arg, err := argAt(args, i)
+	if nil != err {
+		return 0, err
+	}
+	v, err := time.ParseDuration(arg)
+	if nil != err {
+		return 0, fmt.Errorf("argument %d: invalid duration %q", i+1, arg)
+	}
+	return v, nil
+}
+
+func argAt(args []string, i int) (string, error) {
+	if i < 0 || i >= len(args) {
+		return "", fmt.Errorf("argument %d: missing", i+1)
+	}
+	return args[i], nil
+}
+
+var usageTemplate = `
+{{- if .component.Runnable -}}
+{{bold "Usage:"}} {{with .component.Parent}}{{.FullName}} {{end}}{{.component.UsageLine}}
+{{- range .component.ArgNames}} <{{.}}>{{end}}
+{{end}}
+{{- if ne (len .component.Long) 0 -}}
+{{.component.Long | trim}}
+{{end}}
+{{- if ne (len .examples) 0}}
+{{bold "Examples:"}}
+{{- range .examples}}
+  {{dim (printf "# %s" .Description)}}
+  {{.Command}}
+{{- end}}
+{{end}}
+{{- if and (ne (len .component.Components) 0) (not .component.HideComponentsInUsage)}}
+{{- range .groups}}
+{{with .Name}}{{printf "%s:" . | bold}}{{else}}{{bold "The components are:"}}{{end}}
+{{- range .Components}}
+{{- if and .Runnable .Enabled}}
+  {{if $.component.VerboseComponentList}}{{cyan .UsageLine}}
+{{- else}}{{aliased . | printf "%-11s" | cyan}} {{dim .Short}}{{end -}}
+{{end -}}
+{{end}}
+{{end}}
+{{- end}}
+{{- if ne (len .flags) 0}}
+{{bold "The flags are:"}}
+{{.flags -}}
+{{end}}
+{{- range .globalFlags}}
+{{printf "Global flags from %s:" .Name | bold}}
+{{.Flags -}}
+{{end}}`
+
+// Usage prints out the usage information, or calls UsageFunc if it is set
+func (c *Component) Usage() {
+	if nil != c.UsageFunc {
+		if err := c.UsageFunc(c); nil != err {
+			fmt.Fprintln(c.FlagSet().Output(), err)
+		}
+		return
+	}
+
+	local, global := c.splitFlags()
+
+	// Capture the output of the flagsets so that it can be merged with the
+	// rest of the message
+	var inherited []globalFlags
+	for _, g := range global {
+		inherited = append(inherited, globalFlags{
+			Name:  g.component.FullName(),
+			Flags: c.printFlags(g.flags),
+		})
+	}
+
+	text := usageTemplate
+	if "" != c.UsageTemplate {
+		text = c.UsageTemplate
+	}
+
+	c.render(c.FlagSet().Output(), text, map[string]interface{}{
+		"flags":       c.printFlags(local),
+		"globalFlags": inherited,
+		"groups":      c.groupComponents(),
+		"examples":    c.examples,
+	})
+}
+
+// componentGroup are the sub-components listed under one heading
+type componentGroup struct {
+	// Name is the Group of the sub-components, empty for the default group
+	Name string
+
+	// Components are the sub-components in the group
+	Components []*Component
+}
+
+// groupComponents groups the listed sub-components by their Group. The
+// sub-components without a Group come first, followed by the groups in the
+// order they first appear
+func (c *Component) groupComponents() []componentGroup {
+	groups := []componentGroup{{}}
+	index := map[string]int{"": 0}
+	for _, sub := range c.listedComponents() {
+		i, ok := index[sub.Group]
+		if !ok {
+			i = len(groups)
+			index[sub.Group] = i
+			groups = append(groups, componentGroup{Name: sub.Group})
+		}
+		groups[i].Components = append(groups[i].Components, sub)
+	}
+	if 0 == len(groups[0].Components) {
+		groups = groups[1:]
+	}
+	return groups
+}
+
+// globalFlags are the rendered persistent flags inherited from an ancestor
+type globalFlags struct {
+	// Name is the full name of the ancestor declaring the flags
+	Name string
+
+	// Flags are the rendered flags
+	Flags string
+}
+
+// declaredFlags are the flags declared by a component
+type declaredFlags struct {
+	component *Component
+	flags     *flag.FlagSet
+}
+
+// splitFlags splits the set of command line flags of the component into the
+// flags of its own and the persistent flags inherited from each ancestor,
+// nearest first
+func (c *Component) splitFlags() (*flag.FlagSet, []declaredFlags) {
+	local := flag.NewFlagSet(c.Name(), flag.ContinueOnError)
+	global := make(map[*Component]*flag.FlagSet)
+
+	c.FlagSet().VisitAll(func(f *flag.Flag) {
+		flagSet := local
+		for p := c.parent; nil != p; p = p.parent {
+			if nil == p.persistentFlagSet {
+				continue
+			}
+			if pf := p.persistentFlagSet.Lookup(f.Name); nil != pf &&
+				pf.Value == f.Value {
+				if nil == global[p] {
+					global[p] = flag.NewFlagSet(p.Name(), flag.ContinueOnError)
+				}
+				flagSet = global[p]
+				break
+			}
+		}
+		flagSet.Var(f.Value, f.Name, f.Usage)
+		flagSet.Lookup(f.Name).DefValue = f.DefValue
+	})
+
+	var declared []declaredFlags
+	for p := c.parent; nil != p; p = p.parent {
+		if nil != global[p] {
+			declared = append(declared, declaredFlags{p, global[p]})
+		}
+	}
+	return local, declared
+}
+
+// printFlags returns the rendered flags in flagSet
+func (c *Component) printFlags(flagSet *flag.FlagSet) string {
+	var buf bytes.Buffer
+	if c.CompactFlags {
+		c.printCompactFlags(&buf, flagSet)
+	} else {
+		flagSet.SetOutput(&buf)
+		flagSet.PrintDefaults()
+	}
+	return buf.String()
+}
+
+var usageShortTemplate = `
+{{- if .component.Runnable -}}
+{{bold "Usage:"}} {{with .component.Parent}}{{.FullName}} {{end}}{{.component.UsageLine}}
+{{- range .component.ArgNames}} <{{.}}>{{end}}
+{{end}}
+{{- with .component.Short}}{{.}}
+{{end}}
+{{- if and (ne (len .component.Components) 0) (not .component.HideComponentsInUsage)}}
+{{bold "The components are:"}}
+{{- range .components}}
+{{- if and .Runnable .Enabled}} {{cyan .Name}}{{end}}
+{{- end}}
+{{end}}`
+
+// UsageShort prints out the short usage information: the usage line, the
+// short description and the names of the sub-components, without the flags
+func (c *Component) UsageShort() {
+	c.render(c.FlagSet().Output(), usageShortTemplate, map[string]interface{}{})
+}
+
+// render renders the usage template text for the component to w, with data
+// and the component itself as "component", or prints the error if the
+// template is invalid. The rendered message is normalised so that it never
+// starts with a blank line and ends with exactly one newline
+func (c *Component) render(w io.Writer, text string,
+	data map[string]interface{}) {
+	data["component"] = c
+	data["components"] = c.listedComponents()
+
+	// The template may be a UsageTemplate given by the user, so its errors
+	// are reported like the errors of UsageFunc
+	var usage bytes.Buffer
+	if err := tmpl(&usage, text, data, c.colored(w)); nil != err {
+		fmt.Fprintln(w, err)
+		return
+	}
+	if text := strings.Trim(usage.String(), "\n"); "" != text {
+		fmt.Fprintln(w, text)
+	}
+}
+
+// listedComponents returns the sub-components in the order they are listed
+// in the usage information
+func (c *Component) listedComponents() []*Component {
+	if !c.SortComponents {
+		return c.Components
+	}
+	components := append([]*Component(nil), c.Components...)
+	sort.SliceStable(components, func(i, j int) bool {
+		return components[i].Name() < components[j].Name()
+	})
+	return components
+}
+
+// usageWidth is the width that compact flags are wrapped to
+var usageWidth = 80
+
+// printCompactFlags prints the flags in flagSet in two columns, the
+// descriptions wrapped to usageWidth
+func (c *Component) printCompactFlags(w io.Writer, flagSet *flag.FlagSet) {
+	indent := c.FlagIndent
+	if 0 == indent {
+		indent = 2
+	}
+
+	var names, descs []string
+	flagSet.VisitAll(func(f *flag.Flag) {
+		typ, usage := flag.UnquoteUsage(f)
+		name := strings.Repeat(" ", indent) + "-" + f.Name
+		if "" != typ {
+			name += " " + typ
+		}
+		switch f.DefValue {
+		case "", "0", "false":
+		default:
+			if "string" == typ {
+				usage += fmt.Sprintf(" (default %q)", f.DefValue)
+			} else {
+				usage += fmt.Sprintf(" (default %v)", f.DefValue)
+			}
+		}
+		names = append(names, name)
+		descs = append(descs, usage)
+	})
+
+	width := 0
+	for _, name := range names {
+		if len(name) > width {
+			width = len(name)
+		}
+	}
+	width += 2
+
+	for i, name := range names {
+		lines := wrap(descs[i], usageWidth-width)
+		fmt.Fprintf(w, "%-*s%s\n", width, name, lines[0])
+		for _, line := range lines[1:] {
+			fmt.Fprintf(w, "%s%s\n", strings.Repeat(" ", width), line)
+		}
+	}
+}
+
+// wrap splits text into lines of at most width characters, breaking at
+// spaces. Words longer than width are kept on their own line
+func wrap(text string, width int) []string {
+	words := strings.Fields(text)
+	if len(words) == 0 {
+		return []string{""}
+	}
+
+	var lines []string
+	line := words[0]
+	for _, word := range words[1:] {
+		if len(line)+1+len(word) > width {
+			lines = append(lines, line)
+			line = word
+		} else {
+			line += " " + word
+		}
+	}
+	return append(lines, line)
+}
+
+// UsageRecursive prints the usage information of the component and all of its
+// enabled descendants to w, each preceded by a header with the component's
+// path. The descendants are linked to their parents, as when dispatched to,
+// so that their usage information shows their full names and inherited flags
+func (c *Component) UsageRecursive(w io.Writer) {
+	c.usageRecursive(w, c.Name())
+}
+
+func (c *Component) usageRecursive(w io.Writer, path string) {
+	fmt.Fprintf(w, "==> %s <==\n", path)
+
+	flagSet := c.FlagSet()
+	output := flagSet.Output()
+	flagSet.SetOutput(w)
+	c.Usage()
+	flagSet.SetOutput(output)
+
+	for _, child := range c.Components {
+		if !child.Enabled() {
+			continue
+		}
+		child.link(c)
+		fmt.Fprintln(w)
+		child.usageRecursive(w, path+" "+child.Name())
+	}
+}
+
+// GenCheatSheet writes a listing of the component and all of its enabled
+// descendants to w, one line per component with its path followed by its
+// flags
+func (c *Component) GenCheatSheet(w io.Writer) {
+	c.genCheatSheet(w, c.Name())
+}
+
+func (c *Component) genCheatSheet(w io.Writer, path string) {
+	var flags []string
+	c.FlagSet().VisitAll(func(f *flag.Flag) {
+		flags = append(flags, "--"+f.Name)
+	})
+
+	if len(flags) > 0 {
+		fmt.Fprintf(w, "%s  %s\n", path, strings.Join(flags, " "))
+	} else {
+		fmt.Fprintln(w, path)
+	}
+
+	for _, child := range c.Components {
+		if child.Enabled() {
+			child.genCheatSheet(w, path+" "+child.Name())
+		}
+	}
+}
+
+// ApplyDefaultsFromConfig reads a JSON configuration from r, setting the
+// default sub-component and the default values of flags of the component.
+// The configuration is in the form:
+//
+//     {
+//         "defaultCommand": "name",
+//         "flags": {"flag": "value"}
+//     }
+//
+// The default sub-component is dispatched to by Passthrough when no name is
+// given on the command line. Flags given on the command line still override
+// the defaults
+func (c *Component) ApplyDefaultsFromConfig(r io.Reader) error {
+	defaultCommand, flags, err := readConfig(r)
+	if nil != err {
+		return err
+	}
+
+	for name, value := range flags {
+		if err := c.setDefault(name, fmt.Sprint(value)); nil != err {
+			return err
+		}
+	}
+	c.defaultCommand = defaultCommand
+	return nil
+}
+
+// readConfig reads the JSON configuration described in
+// ApplyDefaultsFromConfig from r
+func readConfig(r io.Reader) (string, map[string]interface{}, error) {
+	var config struct {
+		DefaultCommand string                 `json:"defaultCommand"`
+		Flags          map[string]interface{} `json:"flags"`
+	}
+	if err := json.NewDecoder(r).Decode(&config); nil != err {
+		return "", nil, err
+	}
+	return config.DefaultCommand, config.Flags, nil
+}
+
+// LoadConfigYAML reads a YAML configuration of flag names to values from r,
+// setting the flags of the component. Flags given on the command line
+// afterwards override the values from the configuration.
+//
+// Only a flat mapping of scalars is supported, e.g.:
+//
+//     # comment
+//     port: 8080
+//     name: "my app"
+func (c *Component) LoadConfigYAML(r io.Reader) error {
+	flagSet := c.FlagSet()
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if "" == trimmed || strings.HasPrefix(trimmed, "#") || "---" == trimmed {
+			continue
+		}
+		if line != strings.TrimLeft(line, " \t") ||
+			strings.HasPrefix(trimmed, "- ") {
+			return fmt.Errorf("line %d: only flat mappings are supported", n)
+		}
+
+		i := strings.Index(trimmed, ":")
+		if i < 0 {
+			return fmt.Errorf("line %d: expected name: value", n)
+		}
+		name := strings.TrimSpace(trimmed[:i])
+		value := yamlScalar(strings.TrimSpace(trimmed[i+1:]))
+
+		if err := flagSet.Set(name, value); nil != err {
+			return fmt.Errorf("line %d: %v", n, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// yamlScalar returns the value of the YAML scalar s, removing quotes or a
+// trailing comment
+func yamlScalar(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') {
+		if i := strings.IndexByte(s[1:], s[0]); i >= 0 {
+			return s[1 : i+1]
+		}
+	}
+	if i := strings.Index(s, " #"); i >= 0 {
+		s = strings.TrimSpace(s[:i])
+	}
+	return s
+}
+
+// setDefault sets the default value of the named flag, without marking the
+// flag as set on the command line
+func (c *Component) setDefault(name, value string) error {
+	f := c.FlagSet().Lookup(name)
+	if nil == f {
+		return fmt.Errorf("flag provided but not defined: -%s", name)
+	}
+	if err := f.Value.Set(value); nil != err {
+		return fmt.Errorf("invalid value %q for flag -%s: %v", value, name, err)
+	}
+	f.DefValue = value
+	return nil
+}
+
+// PrintTree writes an outline of the component and all of its enabled
+// descendants to w, one per line with its Short description, indented by two
+// spaces per level
+func (c *Component) PrintTree(w io.Writer) {
+	c.printTree(w, 0)
+}
+
+func (c *Component) printTree(w io.Writer, depth int) {
+	line := strings.Repeat("  ", depth) + c.Name()
+	if "" != c.Short {
+		line += " - " + c.Short
+	}
+	fmt.Fprintln(w, line)
+
+	for _, child := range c.Components {
+		if child.Enabled() {
+			child.printTree(w, depth+1)
+		}
+	}
+}
+
+// FlagDefaults returns the default values of the flags of the component,
+// keyed by flag name. Flags inherited from the parent's ChildFlagSet are
+// included once the component has been dispatched to
+func (c *Component) FlagDefaults() map[string]string {
+	defaults := make(map[string]string)
+	c.FlagSet().VisitAll(func(f *flag.Flag) {
+		defaults[f.Name] = f.DefValue
+	})
+	return defaults
+}
+
+// LintDocs returns an error for each runnable descendant of the component
+// without a Short description, which it is listed by in the usage
+// information of its parent
+func (c *Component) LintDocs() []error {
+	return c.lintDocs(c.Name(), nil)
+}
+
+func (c *Component) lintDocs(path string, errs []error) []error {
+	for _, child := range c.Components {
+		childPath := path + " " + child.Name()
+		if child.Runnable() && "" == child.Short {
+			errs = append(errs, fmt.Errorf("%s: missing Short description",
+				childPath))
+		}
+		errs = child.lintDocs(childPath, errs)
+	}
+	return errs
+}
+
+// LintFlags returns an error for each flag of the component or of its
+// descendants shadowing a persistent flag of the same name declared by the
+// component itself or by an ancestor, which the flag silently overrides
+func (c *Component) LintFlags() []error {
+	return c.lintFlags(c.Name(), nil, nil)
+}
+
+func (c *Component) lintFlags(path string, persistent []declaredFlags,
+	errs []error) []error {
+	shadows := func(f *flag.Flag, persistent []declaredFlags) {
+		for _, p := range persistent {
+			if pf := p.flags.Lookup(f.Name); nil != pf && pf.Value != f.Value {
+				errs = append(errs, fmt.Errorf(
+					"%s: flag -%s shadows the persistent flag of %s", path,
+					f.Name, p.component.Name()))
+				return
+			}
+		}
+	}
+
+	if nil != c.persistentFlagSet {
+		c.persistentFlagSet.VisitAll(func(f *flag.Flag) {
+			shadows(f, persistent)
+		})
+		persistent = append([]declaredFlags{{c, c.persistentFlagSet}},
+			persistent...)
+	}
+	c.FlagSet().VisitAll(func(f *flag.Flag) {
+		shadows(f, persistent)
+	})
+
+	for _, child := range c.Components {
+		errs = child.lintFlags(path+" "+child.Name(), persistent, errs)
+	}
+	return errs
+}
+
+// AllFlags returns the names of the flags of the component and all of its
+// descendants, keyed by the path of each component. The names include the
+// flags inherited from the parent's ChildFlagSet and from the
+// PersistentFlagSet of the ancestors
+func (c *Component) AllFlags() map[string][]string {
+	flags := make(map[string][]*flag.Flag)
+	c.allFlags(flags, c.Name(), nil)
+
+	names := make(map[string][]string)
+	for path, fs := range flags {
+		names[path] = []string{}
+		for _, f := range fs {
+			names[path] = append(names[path], f.Name)
+		}
+	}
+	return names
+}
+
+// allFlags collects the flags of the component and all of its descendants
+// into flags, keyed by path and sorted by name
+func (c *Component) allFlags(flags map[string][]*flag.Flag, path string,
+	inherited []*flag.FlagSet) {
+	seen := make(map[string]bool)
+	fs := []*flag.Flag{}
+	flagSets := append([]*flag.FlagSet{c.FlagSet(), c.persistentFlagSet},
+		inherited...)
+	for _, flagSet := range flagSets {
+		if nil == flagSet {
+			continue
+		}
+		flagSet.VisitAll(func(f *flag.Flag) {
+			if !seen[f.Name] {
+				seen[f.Name] = true
+				fs = append(fs, f)
+			}
+		})
+	}
+	sort.Slice(fs, func(i, j int) bool {
+		return fs[i].Name < fs[j].Name
+	})
+	flags[path] = fs
+
+	// Children inherit the persistent flags of all the ancestors, but only
+	// the child flags of their parent
+	persistent := []*flag.FlagSet{c.persistentFlagSet}
+	if 0 != len(inherited) {
+		persistent = append(persistent, inherited[:len(inherited)-1]...)
+	}
+	for _, child := range c.Components {
+		child.allFlags(flags, path+" "+child.Name(),
+			append(persistent, c.childFlagSet))
+	}
+}
+
+// outputKey is the context key for the output of commands
+type outputKey struct{}
+
+// WithOutput returns a copy of ctx in which the output of commands is w, so
+// that Run functions writing to OutputFromContext write to the destination of
+// the context they are executed with, e.g. a buffer in tests. The usage
+// information and errors are still written to the output set by SetOutput.
+//
+// A tree must not be executed from several goroutines at once: Execute and
+// Passthrough parse the flags held by the components and link them to their
+// parents
+func WithOutput(ctx context.Context, w io.Writer) context.Context {
+	return context.WithValue(ctx, outputKey{}, w)
+}
+
+// OutputFromContext returns the output of commands in ctx set by WithOutput,
+// or os.Stdout if there is none
+func OutputFromContext(ctx context.Context) io.Writer {
+	if w, ok := ctx.Value(outputKey{}).(io.Writer); ok {
+		return w
+	}
+	return os.Stdout
+}
+
+// HelpComponent returns a help component for root. Run with the path of a
+// descendant of root, e.g. "help remote add", it prints the usage information
+// of that descendant. Run without arguments, it prints the usage information
+// of root.
+//
+// If no descendant matches the path, the error is printed followed by the
+// usage information of root, and Execute returns an ErrUnknownCommand, so
+// RunMain exits with status 2
+func HelpComponent(root *Component) *Component {
+	// resolve returns the descendant of root at path, linked to its
+	// ancestors so that its usage information shows its full name and
+	// inherited flags, reporting the error if there is none
+	resolve := func(path []string) (*Component, error) {
+		target := root
+		for _, name := range path {
+			next := target.child(name)
+			if nil == next {
+				err := ErrUnknownCommand{Name: name}
+				fmt.Fprintln(root.FlagSet().Output(), err)
+				root.FlagSet().Usage()
+				return nil, reportedError{err}
+			}
+			next.link(target)
+			target = next
+		}
+		return target, nil
+	}
+	return &Component{
+		UsageLine: "help [component...]",
+		Short:     "show the usage information of a component",
+		Args: func(c *Component, args []string) error {
+			_, err := resolve(args)
+			return err
+		},
+		Run: func(ctx context.Context, comp *Component, args []string) {
+			if target, err := resolve(args); nil == err {
+				target.FlagSet().Usage()
+			}
+		},
+	}
+}
+
+// VersionComponent returns a version component, printing the version, commit
+// and build date in the form:
+//
+//     tool version v1.2.3 (abc123, built 2024-01-02)
+//
+// where tool is the full name of the component it is dispatched from. The
+// commit and date are omitted when empty. The line is printed to the output
+// of the component, as set by SetOutput
+func VersionComponent(version, commit, date string) *Component {
+	return &Component{
+		UsageLine: "version",
+		Short:     "print the version",
+		Run: func(ctx context.Context, comp *Component, args []string) {
+			name := programName()
+			if nil != comp.Parent() {
+				name = comp.Parent().FullName()
+			}
+
+			var details []string
+			if "" != commit {
+				details = append(details, commit)
+			}
+			if "" != date {
+				details = append(details, "built "+date)
+			}
+
+			line := fmt.Sprintf("%s version %s", name, version)
+			if 0 != len(details) {
+				line += " (" + strings.Join(details, ", ") + ")"
+			}
+			fmt.Fprintln(comp.FlagSet().Output(), line)
+		},
+	}
+}
+
+// child returns the enabled sub-component with the given name or alias, or
+// nil if there is none
+func (c *Component) child(name string) *Component {
+	for _, child := range c.Components {
+		if child.HasName(name) && child.Enabled() {
+			return child
+		}
+	}
+	return nil
+}
+
+// ErrNoCommand is returned by Execute when no sub-component name is given
+var ErrNoCommand = errors.New("no command given")
+
+// ErrHelp is returned by Execute when help is requested with a -help or
+// -help-short flag, after printing the usage information. It is the same
+// error as flag.ErrHelp, so callers can detect either to exit with 0. With
+// ExitOnError, the flag package exits with 0 itself on -help
+var ErrHelp = flag.ErrHelp
+
+// ErrUnknownCommand is returned by Execute when no runnable sub-component
+// matches the name given
+type ErrUnknownCommand struct {
+	// Name is the name given on the command line
+	Name string
+
+	// Suggestions are the names and aliases of the sub-components within
+	// SuggestionDistance of Name, closest first
+	Suggestions []string
+}
+
+func (e ErrUnknownCommand) Error() string {
+	return fmt.Sprintf("unknown command: %s", e.Name)
+}
+
+// Execute parses args with the set of command line flags of comp, and runs the
+// sub-component named by the first remaining argument with the arguments
+// after it. If the sub-component is not runnable but has sub-components of
+// its own, the dispatch continues recursively from it.
+//
+// Given a -help flag, Execute prints the usage information of comp with Usage
+// and returns ErrHelp. Given a -help-short flag, it prints the short usage
+// information with UsageShort instead. The -help-short flag is accepted by
+// the sub-components dispatched to as well, including the leaves.
+//
+// Unlike Passthrough, Execute does not print anything on failure, but returns
+// the error: ErrNoCommand if no name is given, ErrUnknownCommand if no
+// runnable sub-component matches the name and there is no Fallback, or the
+// error from parsing the flags.
+//
+// Arguments after a "--" terminator are positional arguments of comp
+// itself, and are never matched against the sub-components: they are passed
+// to the Fallback, if any.
+//
+// Once ctx is done, Execute returns ctx.Err() instead of running a component
+// or dispatching further. Run implementations remain responsible for
+// honoring the cancellation of ctx while they run
+func Execute(ctx context.Context, comp *Component, args []string) error {
+	_, err := execute(ctx, comp, args)
+	return cause(err)
+}
+
+// RunExpectSilent executes c with args like Execute, capturing the output of
+// commands set with WithOutput. It returns an error if anything was written
+// to the output, for testing commands that should be silent on success
+func (c *Component) RunExpectSilent(ctx context.Context,
+	args ...string) error {
+	var out bytes.Buffer
+	if err := Execute(WithOutput(ctx, &out), c, args); nil != err {
+		return err
+	}
+	if 0 != out.Len() {
+		return fmt.Errorf("%s wrote to the output: %q", c.Name(), out.String())
+	}
+	return nil
+}
+
+// path returns the components from the root down to the component
+func (c *Component) path() []*Component {
+	var path []*Component
+	for p := c; nil != p; p = p.parent {
+		path = append([]*Component{p}, path...)
+	}
+	return path
+}
+
+// preRun runs the PreRun hooks on the path to the leaf component c
+func (c *Component) preRun(ctx context.Context, args []string) error {
+	for _, p := range c.path() {
+		if nil == p.PreRun {
+			continue
+		}
+		if err := p.PreRun(ctx, c, args); nil != err {
+			return err
+		}
+	}
+	return nil
+}
+
+// postRun runs the PostRun hooks on the path to the leaf component c, from
+// the leaf up
+func (c *Component) postRun(ctx context.Context, args []string) error {
+	var err error
+	path := c.path()
+	for i := len(path) - 1; i >= 0; i-- {
+		if nil == path[i].PostRun {
+			continue
+		}
+		if e := path[i].PostRun(ctx, c, args); nil == err {
+			err = e
+		}
+	}
+	return err
+}
+
+
+// reportedError is an error that has already been reported to the user
+type reportedError struct {
+	error
+}
+
+// usageError is an error in the usage of a component, e.g. an invalid flag
+// value or missing arguments, reported with its usage information
+type usageError struct {
+	error
+}
+
+// cause returns the error wrapped by a reportedError or a usageError, or err
+// itself
+func cause(err error) error {
+	switch e := err.(type) {
+	case reportedError:
+		return e.error
+	case usageError:
+		return e.error
+	}
+	return err
+}
+
+// execute implements Execute, also returning the deepest component reached
+func execute(ctx context.Context, comp *Component,
+	args []string) (*Component, error) {
+	comp.inheritPersistentFlags()
+
+	if err := comp.parse(args); nil != err {
+		return comp, err
+	}
+
+	rest := comp.FlagSet().Args()
+	terminated := len(rest) > 0 && comp.terminated(args)
+	if 0 == len(rest) && "" != comp.defaultCommand {
+		rest = []string{comp.defaultCommand}
+	}
+
+	if len(rest) < 1 {
+		return comp, ErrNoCommand
+	}
+
+	comp.emit(StageParsed, comp, rest)
+
+	name := rest[0]
+	args = rest[1:]
+
+	if router := comp.Root().Router; nil != router && !terminated {
+		c, args, err := router(comp, rest)
+		if nil != err {
+			return comp, err
+		}
+		if nil != c {
+			return dispatch(ctx, comp, c, args)
+		}
+	}
+
+	for _, c := range comp.Components {
+		if terminated || !c.HasName(name) || !c.Enabled() {
+			continue
+		}
+		if !c.Runnable() && 0 == len(c.Components) {
+			continue
+		}
+		return dispatch(ctx, comp, c, args)
+	}
+
+	if nil != comp.Fallback {
+		comp.Fallback(ctx, comp, name, args)
+		return comp, nil
+	}
+	return comp, ErrUnknownCommand{
+		Name:        name,
+		Suggestions: comp.suggest(name),
+	}
+}
+
+// terminated returns whether the flags of the component at the start of
+// args, once parsed, are ended by a "--" terminator
+func (c *Component) terminated(args []string) bool {
+	args, err := c.expandFlags(args)
+	if nil != err {
+		return false
+	}
+	_, terminated := flagArgs(c.FlagSet(), args)
+	return terminated
+}
+
+// dispatch runs c, matched from comp, with args
+func dispatch(ctx context.Context, comp, c *Component,
+	args []string) (_ *Component, err error) {
+	if err := comp.checkExperimental(c); nil != err {
+		return comp, err
+	}
+	if err := comp.checkGlobalOnly(c, args); nil != err {
+		return comp, err
+	}
+	c.link(comp)
+	comp.emit(StageMatched, c, args)
+
+	// Components with sub-components dispatch to them, so dispatch
+	// continues through them. Doing so here rather than through their Run,
+	// which may wrap Passthrough, returns the errors of the dispatch to the
+	// caller
+	if 0 != len(c.Components) {
+		if err := c.checkPrecondition(); nil != err {
+			return c, err
+		}
+		if err := ctx.Err(); nil != err {
+			return c, err
+		}
+		return execute(ctx, c, args)
+	}
+
+	// Leaves without Args parse their flags themselves when run, so the
+	// short usage information is printed before running them
+	if hasFlag(c.FlagSet(), args, helpShortFlag) {
+		c.UsageShort()
+		return c, ErrHelp
+	}
+
+	if nil != c.Args {
+		if err := c.parse(args); nil != err {
+			return c, err
+		}
+		if err := c.Args(c, c.FlagSet().Args()); nil != err {
+			if _, ok := err.(reportedError); !ok {
+				err = usageError{err}
+			}
+			return c, err
+		}
+		args = c.FlagSet().Args()
+	}
+
+	if c.Root().printCommandSet() {
+		return c, c.printCommand(ctx, args)
+	}
+
+	// Leaves without Args parse their flags themselves when run, so the
+	// configuration is applied beforehand, for their command line to
+	// override it
+	if nil == c.Args {
+		if err := c.loadConfigFlag(); nil != err {
+			return c, usageError{err}
+		}
+	}
+
+	if err := c.checkPrecondition(); nil != err {
+		return c, err
+	}
+
+	if root := c.Root(); nil != root.OnResolved {
+		root.OnResolved(c, args)
+	}
+
+	// PostRun hooks clean up after the PreRun hooks, so they run even if a
+	// PreRun hook fails
+	defer func() {
+		if postErr := c.postRun(ctx, args); nil == err {
+			err = postErr
+		}
+	}()
+	if err := c.preRun(ctx, args); nil != err {
+		return c, err
+	}
+
+	// A Run is not started once the context is done, e.g. cancelled during
+	// the PreRun hooks
+	if err := ctx.Err(); nil != err {
+		return c, err
+	}
+
+	comp.emit(StageRun, c, args)
+	c.Run(ctx, c, args)
+	comp.emit(StageDone, c, args)
+	return c, nil
+}
+
+// checkPrecondition checks the Precondition of the component, if any
+func (c *Component) checkPrecondition() error {
+	if nil == c.Precondition {
+		return nil
+	}
+	return c.Precondition()
+}
+
+// printCommandFlag is the name of the flag added by EnablePrintCommand
+const printCommandFlag = "print-command"
+
+// printCommandSet returns whether the flag added by EnablePrintCommand is set
+func (c *Component) printCommandSet() bool {
+	return c.EnablePrintCommand &&
+		"true" == c.FlagSet().Lookup(printCommandFlag).Value.String()
+}
+
+// printCommand prints the full name of the component followed by its
+// positional arguments, then the values of the flags of the components on
+// its path, parsing its own flags from args if Execute has not
+func (c *Component) printCommand(ctx context.Context, args []string) error {
+	if nil == c.Args {
+		if err := c.parse(args); nil != err {
+			return err
+		}
+	}
+
+	// The flags of the component take precedence over the flags of its
+	// ancestors with the same names
+	values := make(map[string]string)
+	path := c.path()
+	for i := len(path) - 1; i >= 0; i-- {
+		path[i].FlagSet().VisitAll(func(f *flag.Flag) {
+			if _, ok := values[f.Name]; !ok && printCommandFlag != f.Name {
+				values[f.Name] = f.Value.String()
+			}
+		})
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w := OutputFromContext(ctx)
+	fmt.Fprintln(w, strings.Join(append([]string{c.FullName()},
+		c.FlagSet().Args()...), " "))
+	for _, name := range names {
+		fmt.Fprintf(w, "  -%s=%s\n", name, values[name])
+	}
+	return nil
+}
+
+// SuggestionDistance is the maximum edit distance between a mistyped name and
+// the names of the sub-components for them to be suggested. Setting it to 0
+// disables suggestions
+var SuggestionDistance = 2
+
+// suggest returns the names and aliases of the dispatchable sub-components
+// within SuggestionDistance of name, closest first
+func (c *Component) suggest(name string) []string {
+	var suggestions []string
+	distances := make(map[string]int)
+	for _, child := range c.Components {
+		if !child.Enabled() || !child.Runnable() && 0 == len(child.Components) {
+			continue
+		}
+		for _, candidate := range append([]string{child.Name()}, child.Aliases...) {
+			if _, ok := distances[candidate]; ok {
+				continue
+			}
+			d := levenshtein(name, candidate)
+			if d > 0 && d <= SuggestionDistance {
+				distances[candidate] = d
+				suggestions = append(suggestions, candidate)
+			}
+		}
+	}
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		return distances[suggestions[i]] < distances[suggestions[j]]
+	})
+	return suggestions
+}
+
+// levenshtein returns the edit distance between a and b
+func levenshtein(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	prev := make([]int, len(t)+1)
+	curr := make([]int, len(t)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		curr[0] = i
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			curr[j] = min3(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(t)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// RunMain runs Execute for root with args, in a context cancelled on SIGINT
+// and SIGTERM, and returns the exit code for the program, e.g.:
+//
+//     os.Exit(cli.RunMain(root, os.Args[1:]))
+//
+// The exit code is 0 on success or if help is requested, 2 on a usage
+// error: a missing or unknown sub-component, invalid flags or flag values,
+// or positional arguments rejected by Args, and 1 on any other error.
+// Errors are printed, followed by the usage information for usage errors
+func RunMain(root *Component, args []string) int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt,
+		syscall.SIGTERM)
+	defer stop()
+
+	comp, err := execute(ctx, root, args)
+	if nil == err || ErrHelp == err {
+		return 0
+	}
+
+	flagSet := comp.FlagSet()
+	switch e := err.(type) {
+	case reportedError:
+		return 2
+	case usageError:
+		fmt.Fprintln(flagSet.Output(), e)
+		flagSet.Usage()
+		return 2
+	case ErrUnknownCommand:
+		fmt.Fprintln(flagSet.Output(), e)
+		if 0 != len(e.Suggestions) {
+			fmt.Fprintf(flagSet.Output(), "Did you mean: %s?\n",
+				strings.Join(e.Suggestions, ", "))
+		}
+		flagSet.Usage()
+		return 2
+	}
+	if ErrNoCommand == err {
+		flagSet.Usage()
+		return 2
+	}
+	fmt.Fprintln(flagSet.Output(), err)
+	return 1
+}
+
+// RunWithEnv runs Execute for the component with the environment variables
+// in env set, restoring the environment afterwards.
+//
+// As the environment is process wide, RunWithEnv must not be called
+// concurrently with code depending on the variables in env
+func (c *Component) RunWithEnv(ctx context.Context, env map[string]string,
+	args []string) error {
+	for key, value := range env {
+		if old, ok := os.LookupEnv(key); ok {
+			defer os.Setenv(key, old)
+		} else {
+			defer os.Unsetenv(key)
+		}
+		if err := os.Setenv(key, value); nil != err {
+			return err
+		}
+	}
+
+	return Execute(ctx, c, args)
+}
+
+// Passthrough is a implementation of the Run function that passes the
+// execution through the sub commands.
+//
+// Passthrough runs Execute, printing the usage information of the deepest
+// component reached on failure. The usage is preceded by the error, or by
+// suggestions if the name of the sub-component is mistyped
+func Passthrough(ctx context.Context, comp *Component, args []string) {
+	comp, err := execute(ctx, comp, args)
+	if nil == err || ErrHelp == err {
+		return
+	}
+
+	flagSet := comp.FlagSet()
+	if ctx.Err() == err {
+		// The dispatch was cancelled, so the usage is of no help
+		fmt.Fprintln(flagSet.Output(), err)
+		return
+	}
+	switch e := err.(type) {
+	case reportedError:
+		return
+	case ErrUnknownCommand:
+		if 0 != len(e.Suggestions) {
+			fmt.Fprintf(flagSet.Output(), "Did you mean: %s?\n",
+				strings.Join(e.Suggestions, ", "))
+		}
+	default:
+		if ErrNoCommand != err {
+			fmt.Fprintln(flagSet.Output(), err)
+		}
+	}
+	flagSet.Usage()
+}
+
+// experimentalEnv returns the name of the environment variable enabling
+// the experimental components of the tree of c. Characters not allowed in
+// the names of environment variables are replaced by underscores
+func (c *Component) experimentalEnv() string {
+	root := c.Root()
+	name := root.Name()
+	if "" == root.UsageLine {
+		// The name of the binary, e.g. tool.exe on Windows
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+
+	name = strings.Map(func(r rune) rune {
+		if 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' {
+			return r
+		}
+		return '_'
+	}, strings.ToUpper(name))
+	return name + "_EXPERIMENTAL"
+}
+
+func (c *Component) checkExperimental(sub *Component) error {
+	if !sub.Experimental || "1" == os.Getenv(c.experimentalEnv()) {
+		return nil
+	}
+	return fmt.Errorf("%s is experimental; set %s=1 to enable it",
+		sub.Name(), c.experimentalEnv())
+}
+
+// checkGlobalOnly reports the first global only flag of c found in args,
+// the arguments of its sub-component sub
+func (c *Component) checkGlobalOnly(sub *Component, args []string) error {
+	for _, arg := range args {
+		if "--" == arg {
+			return nil
+		}
+		for _, name := range c.globalOnlyFlags {
+			if isFlag(arg, name) {
+				return fmt.Errorf("flag -%s must be given before %s", name,
+					sub.Name())
+			}
+		}
+	}
+	return nil
+}
+
+// helpShortFlag is the name of the flag requesting the short usage
+// information
+const helpShortFlag = "help-short"
+
+// hasFlag returns whether the named flag is among the flags at the start of
+// args, before the first non-flag argument. Values of the flags defined in
+// flagSet are skipped
+func hasFlag(flagSet *flag.FlagSet, args []string, name string) bool {
+	flags, _ := flagArgs(flagSet, args)
+	for _, arg := range flags {
+		if isFlag(arg, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// flagArgs returns the flags at the start of args, before the first non-flag
+// argument, and whether they are ended by a "--" terminator. Values of the
+// flags defined in flagSet are skipped, so a "--" given as the value of a
+// flag is not taken as the terminator
+func flagArgs(flagSet *flag.FlagSet, args []string) ([]string, bool) {
+	var flags []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if "--" == arg {
+			return flags, true
+		}
+		if len(arg) < 2 || '-' != arg[0] {
+			return flags, false
+		}
+		flags = append(flags, arg)
+
+		if strings.Contains(arg, "=") {
+			continue
+		}
+		f := flagSet.Lookup(strings.TrimLeft(arg, "-"))
+		if nil != f && !isBoolFlag(f) {
+			i++
+		}
+	}
+	return flags, false
+}
+
+// isBoolFlag returns whether f is a boolean flag, which takes no value
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// isFlag returns whether arg is the named flag, in any of the forms
+// accepted by the flag package
+func isFlag(arg, name string) bool {
+	arg = strings.TrimPrefix(arg, "-")
+	arg = strings.TrimPrefix(arg, "-")
+	if i := strings.Index(arg, "="); i >= 0 {
+		arg = arg[:i]
+	}
+	return name == arg
+}
+
+// emit calls the OnEvent of the component and of its ancestors, nearest
+// first, with the stage reached by comp
+func (c *Component) emit(stage Stage, comp *Component, args []string) {
+	for p := c; nil != p; p = p.parent {
+		if nil != p.OnEvent {
+			p.OnEvent(Event{Stage: stage, Component: comp, Args: args})
+		}
+	}
+}
+
+// ansi returns a func wrapping non-empty strings in the ANSI escape code if
+// color is set, and returning them unchanged otherwise
+func ansi(code string, color bool) func(string) string {
+	return func(s string) string {
+		if !color || "" == s {
+			return s
+		}
+		return "\x1b[" + code + "m" + s + "\x1b[0m"
+	}
+}
+
+// colored returns whether the usage information of the component written to
+// w is colored: if ForceColor is set on the component or an ancestor, or
+// else if w is a terminal and neither DisableColor nor NO_COLOR is set
+func (c *Component) colored(w io.Writer) bool {
+	path := c.path()
+	for _, p := range path {
+		if p.DisableColor {
+			return false
+		}
+	}
+	for _, p := range path {
+		if p.ForceColor {
+			return true
+		}
+	}
+	if "" != os.Getenv("NO_COLOR") {
+		return false
+	}
+	return isTerminal(w)
+}
+
+// isTerminal returns whether w is a terminal
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return nil == err && 0 != fi.Mode()&os.ModeCharDevice
+}
+
+// aliased returns the name of c followed by its aliases, if any, in
+// parentheses
+func aliased(c *Component) string {
+	if 0 == len(c.Aliases) {
+		return c.Name()
+	}
+	return fmt.Sprintf("%s (%s)", c.Name(), strings.Join(c.Aliases, ", "))
+}
+
+// tmpl renders the template text with data to w. The template can use the
+// funcs bold, cyan and dim, which color their argument if color is set
+func tmpl(w io.Writer, text string, data interface{}, color bool) error {
+	t := template.New("top")
+	t.Funcs(template.FuncMap{
+		"trim":    strings.TrimSpace,
+		"aliased": aliased,
+		"bold":    ansi("1", color),
+		"cyan":    ansi("36", color),
+		"dim":     ansi("2", color),
+	})
+	if _, err := t.Parse(text); nil != err {
+		return err
+	}
+	return t.Execute(w, data)
 }
@@ -17,7 +17,18 @@ package cli
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 )
 
 const UsageLine = `test [-i input]`
@@ -64,7 +75,18 @@ func TestComponent_Name(t *testing.T) {
 			},
 			want: "test",
 		},
+		{
+			name: "Empty UsageLine",
+			c:    &Component{},
+			want: "app",
+		},
+	}
+
+	defer func(f func() string) { programName = f }(programName)
+	programName = func() string {
+		return filepath.Base("/usr/local/bin/app")
 	}
+
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			if got := tt.c.Name(); got != tt.want {
@@ -107,8 +129,7 @@ The flags are:
 			c: &Component{
 				UsageLine: UsageLine,
 			},
-			want: `
-The flags are:
+			want: `The flags are:
   -i string
     	input of the test component
 `,
@@ -136,6 +157,50 @@ The flags are:
 			want: `Usage: test [-i input]
 Long usage line for the application designed to test formatting.
 
+The flags are:
+  -i string
+    	input of the test component
+`,
+		},
+		{
+			name: "Verbose Component List",
+			c: &Component{
+				UsageLine:            UsageLine,
+				Run:                  Passthrough,
+				VerboseComponentList: true,
+				Components: []*Component{
+					&Component{
+						UsageLine: "subcomponent1 [-f] file",
+						Short:     "description of subcomponent 1",
+						Run:       func(context.Context, *Component, []string) {},
+					},
+					&Component{
+						UsageLine: "subcomponent2 name",
+						Short:     "description of subcomponent 2",
+						Run:       func(context.Context, *Component, []string) {},
+					},
+				},
+			},
+			want: `Usage: test [-i input]
+
+The components are:
+  subcomponent1 [-f] file
+  subcomponent2 name
+
+The flags are:
+  -i string
+    	input of the test component
+`,
+		},
+		{
+			name: "With ArgNames",
+			c: &Component{
+				UsageLine: UsageLine,
+				ArgNames:  []string{"src", "dst"},
+				Run:       Passthrough,
+			},
+			want: `Usage: test [-i input] <src> <dst>
+
 The flags are:
   -i string
     	input of the test component
@@ -189,3 +254,2987 @@ The flags are:
 		})
 	}
 }
+
+func TestComponent_UsageRecursive(t *testing.T) {
+	run := func(context.Context, *Component, []string) {}
+	grandchild := &Component{
+		UsageLine: "add [-f] name",
+		Long:      "Long description of the add component.",
+		Run:       run,
+	}
+	child := &Component{
+		UsageLine:  "remote",
+		Short:      "description of remote",
+		Long:       "Long description of the remote component.",
+		Run:        run,
+		Components: []*Component{grandchild},
+	}
+	disabled := &Component{
+		UsageLine:   "disabled",
+		Run:         run,
+		EnabledFunc: func() bool { return false },
+	}
+	root := &Component{
+		UsageLine:  UsageLine,
+		Long:       Long,
+		Run:        Passthrough,
+		Components: []*Component{child, disabled},
+	}
+
+	var buf bytes.Buffer
+	root.UsageRecursive(&buf)
+	got := buf.String()
+
+	want := "==> test remote add <==\nUsage: test remote add [-f] name\n"
+	if !strings.Contains(got, want) {
+		t.Errorf("Component.UsageRecursive() = %v, want it to contain %v",
+			got, want)
+	}
+	if strings.Contains(got, "disabled") {
+		t.Errorf("Component.UsageRecursive() = %v, want no disabled component",
+			got)
+	}
+
+	for _, tt := range []struct {
+		header string
+		c      *Component
+	}{
+		{"==> test <==", root},
+		{"==> test remote <==", child},
+		{"==> test remote add <==", grandchild},
+	} {
+		var usage bytes.Buffer
+		tt.c.SetOutput(&usage)
+		tt.c.Usage()
+
+		if !strings.Contains(got, tt.header+"\n"+usage.String()) {
+			t.Errorf("Component.UsageRecursive() = %v, want it to contain %v",
+				got, tt.header+"\n"+usage.String())
+		}
+	}
+}
+
+func TestComponent_CheckArgs(t *testing.T) {
+	c := &Component{
+		UsageLine: "cp",
+		ArgNames:  []string{"src", "dst"},
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{
+			name: "All Present",
+			args: []string{"a", "b"},
+		},
+		{
+			name: "Missing dst",
+			args: []string{"a"},
+			want: "missing argument: dst",
+		},
+		{
+			name: "Missing src",
+			args: nil,
+			want: "missing argument: src",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := c.CheckArgs(tt.args)
+			if "" == tt.want {
+				if nil != err {
+					t.Errorf("Component.CheckArgs() = %v, want nil", err)
+				}
+				return
+			}
+			if nil == err || err.Error() != tt.want {
+				t.Errorf("Component.CheckArgs() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestComponent_AddFlagValidator(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{
+			name: "In Range",
+			args: []string{"-port", "8080"},
+		},
+		{
+			name: "Out Of Range",
+			args: []string{"-port", "70000"},
+			want: "--port must be between 1 and 65535",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Component{UsageLine: "serve"}
+			c.FlagSet().Int("port", 80, "port to listen on")
+			c.AddFlagValidator("port", func(value string) error {
+				port, err := strconv.Atoi(value)
+				if nil != err || port < 1 || port > 65535 {
+					return errors.New("--port must be between 1 and 65535")
+				}
+				return nil
+			})
+
+			err := c.Parse(tt.args)
+			if "" == tt.want {
+				if nil != err {
+					t.Errorf("Component.Parse() = %v, want nil", err)
+				}
+				return
+			}
+			if nil == err || err.Error() != tt.want {
+				t.Errorf("Component.Parse() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestComponent_HideComponentsInUsage(t *testing.T) {
+	var ran bool
+	c := &Component{
+		UsageLine:             UsageLine,
+		Run:                   Passthrough,
+		HideComponentsInUsage: true,
+		Components: []*Component{
+			&Component{
+				UsageLine: "subcomponent1",
+				Short:     "description of subcomponent 1",
+				Run: func(context.Context, *Component, []string) {
+					ran = true
+				},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	c.SetOutput(&buf)
+	c.Usage()
+	if got := buf.String(); strings.Contains(got, "subcomponent1") {
+		t.Errorf("Component.Usage() = %v, want no components", got)
+	}
+
+	c.Run(context.Background(), c, []string{"subcomponent1"})
+	if !ran {
+		t.Error("Passthrough() did not dispatch to subcomponent1")
+	}
+}
+
+func TestComponent_LongFromFile(t *testing.T) {
+	fsys := fstest.MapFS{
+		"docs/test.txt": &fstest.MapFile{
+			Data: []byte("Long description\nkept in a file.\n"),
+		},
+	}
+	c := &Component{
+		UsageLine: "test",
+		Run:       func(context.Context, *Component, []string) {},
+	}
+	if err := c.LongFromFile(fsys, "docs/missing.txt"); nil == err {
+		t.Error("Component.LongFromFile(missing) = nil, want error")
+	}
+	if err := c.LongFromFile(fsys, "docs/test.txt"); nil != err {
+		t.Fatalf("Component.LongFromFile() = %v, want nil", err)
+	}
+
+	var buf bytes.Buffer
+	c.SetOutput(&buf)
+	c.Usage()
+	want := "Usage: test\nLong description\nkept in a file.\n"
+	if got := buf.String(); got != want {
+		t.Errorf("Component.Usage() = %q, want %q", got, want)
+	}
+}
+
+func TestComponent_UsageTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		want     string
+	}{
+		{
+			name:     "Valid",
+			template: `USAGE: {{.component.UsageLine}} [{{.component.Long | trim}}]`,
+			want:     "USAGE: test [long]\n",
+		},
+		{
+			name:     "Parse Error",
+			template: `{{.bogus`,
+			want:     "unclosed action",
+		},
+		{
+			name:     "Execute Error",
+			template: `{{.component.Bogus}}`,
+			want:     "can't evaluate field Bogus",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Component{
+				UsageLine:     "test",
+				Long:          "  long  ",
+				UsageTemplate: tt.template,
+				Run:           func(context.Context, *Component, []string) {},
+			}
+
+			var buf bytes.Buffer
+			c.SetOutput(&buf)
+			c.Usage()
+			if got := buf.String(); !strings.Contains(got, tt.want) {
+				t.Errorf("Component.Usage() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestComponent_UsageFunc(t *testing.T) {
+	var calls int
+	c := &Component{
+		UsageLine:     "test",
+		ErrorHandling: ContinueOnError,
+		Run:           func(context.Context, *Component, []string) {},
+		UsageFunc: func(c *Component) error {
+			calls++
+			fmt.Fprintf(c.FlagSet().Output(), "custom usage of %s", c.Name())
+			return nil
+		},
+	}
+
+	var buf bytes.Buffer
+	c.SetOutput(&buf)
+	c.Usage()
+	if got, want := buf.String(), "custom usage of test"; got != want {
+		t.Errorf("Component.Usage() = %v, want %v", got, want)
+	}
+
+	c.Parse([]string{"-unknown"})
+	if 2 != calls {
+		t.Errorf("UsageFunc called %d times, want 2", calls)
+	}
+}
+
+func TestComponent_AddExample(t *testing.T) {
+	c := &Component{
+		UsageLine: "tool",
+		Long:      "Tool manages remotes.",
+		Run:       func(context.Context, *Component, []string) {},
+	}
+	c.AddExample("tool add origin", "Add the remote origin")
+	c.AddExample("tool rm origin", "Remove the remote origin")
+	want := `Usage: tool
+Tool manages remotes.
+
+Examples:
+  # Add the remote origin
+  tool add origin
+  # Remove the remote origin
+  tool rm origin
+`
+
+	var buf bytes.Buffer
+	c.SetOutput(&buf)
+	c.Usage()
+	if got := buf.String(); got != want {
+		t.Errorf("Component.Usage() = %v, want %v", got, want)
+	}
+}
+
+func TestComponent_Usage_Color(t *testing.T) {
+	tests := []struct {
+		name    string
+		force   bool
+		disable bool
+		want    string
+	}{
+		{
+			name: "Not a Terminal",
+			want: "Usage: tool\n\nThe components are:\n  sync        sync the remotes\n",
+		},
+		{
+			name:  "Forced",
+			force: true,
+			want: "\x1b[1mUsage:\x1b[0m tool\n\n\x1b[1mThe components are:\x1b[0m\n" +
+				"  \x1b[36msync       \x1b[0m \x1b[2msync the remotes\x1b[0m\n",
+		},
+		{
+			name:    "Disabled",
+			force:   true,
+			disable: true,
+			want:    "Usage: tool\n\nThe components are:\n  sync        sync the remotes\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Component{
+				UsageLine:    "tool",
+				Run:          Passthrough,
+				ForceColor:   tt.force,
+				DisableColor: tt.disable,
+				Components: []*Component{
+					&Component{
+						UsageLine: "sync",
+						Short:     "sync the remotes",
+						Run:       func(context.Context, *Component, []string) {},
+					},
+				},
+			}
+
+			var buf bytes.Buffer
+			c.SetOutput(&buf)
+			c.Usage()
+			if got := buf.String(); got != tt.want {
+				t.Errorf("Component.Usage() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestComponent_SortComponents(t *testing.T) {
+	run := func(context.Context, *Component, []string) {}
+	for _, tt := range []struct {
+		name string
+		sort bool
+		want string
+	}{
+		{"Declaration Order", false, "zebra.*apple"},
+		{"Sorted", true, "apple.*zebra"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Component{
+				UsageLine:      UsageLine,
+				Run:            Passthrough,
+				SortComponents: tt.sort,
+				Components: []*Component{
+					&Component{UsageLine: "zebra", Run: run},
+					&Component{UsageLine: "apple", Run: run},
+				},
+			}
+
+			var buf bytes.Buffer
+			c.SetOutput(&buf)
+			c.Usage()
+			got := strings.Replace(buf.String(), "\n", " ", -1)
+			if !regexp.MustCompile(tt.want).MatchString(got) {
+				t.Errorf("Component.Usage() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestComponent_Usage_Groups(t *testing.T) {
+	run := func(context.Context, *Component, []string) {}
+	c := &Component{
+		UsageLine: "tool",
+		Run:       Passthrough,
+		Components: []*Component{
+			&Component{UsageLine: "ps", Short: "list", Run: run,
+				Group: "Inspection Commands"},
+			&Component{UsageLine: "start", Short: "start", Run: run,
+				Group: "Management Commands"},
+			&Component{UsageLine: "version", Short: "version", Run: run},
+			&Component{UsageLine: "logs", Short: "logs", Run: run,
+				Group: "Inspection Commands"},
+		},
+	}
+	want := `Usage: tool
+
+The components are:
+  version     version
+
+Inspection Commands:
+  ps          list
+  logs        logs
+
+Management Commands:
+  start       start
+`
+
+	var buf bytes.Buffer
+	c.SetOutput(&buf)
+	c.Usage()
+	if got := buf.String(); got != want {
+		t.Errorf("Component.Usage() = %v, want %v", got, want)
+	}
+}
+
+func TestComponent_SetComponentOutput(t *testing.T) {
+	grandchild := &Component{
+		UsageLine: "grandchild",
+		Run:       func(context.Context, *Component, []string) {},
+	}
+	child := &Component{
+		UsageLine:  "child",
+		Run:        Passthrough,
+		Components: []*Component{grandchild},
+	}
+	root := &Component{
+		UsageLine:  UsageLine,
+		Run:        Passthrough,
+		Components: []*Component{child},
+	}
+
+	var rootBuf, childBuf bytes.Buffer
+	child.SetComponentOutput(&childBuf)
+	root.SetOutput(&rootBuf)
+
+	child.Usage()
+	if got, want := childBuf.String(), "Usage: child\n"; !strings.HasPrefix(got, want) {
+		t.Errorf("child output = %v, want prefix %v", got, want)
+	}
+
+	grandchild.Usage()
+	root.Usage()
+	if got := rootBuf.String(); !strings.Contains(got, "Usage: grandchild") ||
+		!strings.Contains(got, "Usage: test") || strings.Contains(got, "Usage: child") {
+		t.Errorf("root output = %v, want usage of root and grandchild only", got)
+	}
+}
+
+func TestArgInt(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		i       int
+		want    int
+		wantErr string
+	}{
+		{
+			name: "Valid",
+			args: []string{"copy", "42"},
+			i:    1,
+			want: 42,
+		},
+		{
+			name:    "Non Numeric",
+			args:    []string{"copy", "abc"},
+			i:       1,
+			wantErr: `argument 2: invalid integer "abc"`,
+		},
+		{
+			name:    "Missing",
+			args:    []string{"copy"},
+			i:       1,
+			wantErr: "argument 2: missing",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ArgInt(tt.args, tt.i)
+			if "" != tt.wantErr {
+				if nil == err || err.Error() != tt.wantErr {
+					t.Errorf("ArgInt() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if nil != err || got != tt.want {
+				t.Errorf("ArgInt() = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestArgDuration(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    time.Duration
+		wantErr string
+	}{
+		{
+			name: "Valid",
+			args: []string{"1m30s"},
+			want: 90 * time.Second,
+		},
+		{
+			name:    "Invalid",
+			args:    []string{"soon"},
+			wantErr: `argument 1: invalid duration "soon"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ArgDuration(tt.args, 0)
+			if "" != tt.wantErr {
+				if nil == err || err.Error() != tt.wantErr {
+					t.Errorf("ArgDuration() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if nil != err || got != tt.want {
+				t.Errorf("ArgDuration() = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestComponent_Fallback(t *testing.T) {
+	var gotName string
+	var gotArgs []string
+	c := &Component{
+		UsageLine: UsageLine,
+		Run:       Passthrough,
+		Components: []*Component{
+			&Component{
+				UsageLine: "subcomponent1",
+				Run:       func(context.Context, *Component, []string) {},
+			},
+		},
+		Fallback: func(ctx context.Context, comp *Component, name string,
+			args []string) {
+			gotName = name
+			gotArgs = args
+		},
+	}
+
+	c.Run(context.Background(), c, []string{"file.txt", "-n", "10"})
+
+	if gotName != "file.txt" {
+		t.Errorf("Fallback name = %v, want %v", gotName, "file.txt")
+	}
+	if want := []string{"-n", "10"}; !reflect.DeepEqual(gotArgs, want) {
+		t.Errorf("Fallback args = %v, want %v", gotArgs, want)
+	}
+}
+
+func TestComponent_MarkFlagRequires(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{
+			name: "Neither",
+			args: nil,
+		},
+		{
+			name: "Both",
+			args: []string{"-cert", "cert.pem", "-key", "key.pem"},
+		},
+		{
+			name: "Cert Without Key",
+			args: []string{"-cert", "cert.pem"},
+			want: "flag -cert requires -key",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Component{UsageLine: "serve"}
+			c.FlagSet().String("cert", "", "certificate file")
+			c.FlagSet().String("key", "", "key file")
+			c.MarkFlagRequires("cert", "key")
+
+			err := c.Parse(tt.args)
+			if "" == tt.want {
+				if nil != err {
+					t.Errorf("Component.Parse() = %v, want nil", err)
+				}
+				return
+			}
+			if nil == err || err.Error() != tt.want {
+				t.Errorf("Component.Parse() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestComponent_OnEvent(t *testing.T) {
+	var events []Event
+	sub := &Component{
+		UsageLine: "subcomponent1",
+		Run: func(context.Context, *Component, []string) {
+			events = append(events, Event{Stage: -1})
+		},
+	}
+	c := &Component{
+		UsageLine:  UsageLine,
+		Run:        Passthrough,
+		Components: []*Component{sub},
+		OnEvent: func(e Event) {
+			events = append(events, e)
+		},
+	}
+	c.FlagSet().String("i", "", "input of the test component")
+
+	c.Run(context.Background(), c, []string{"-i", "in", "subcomponent1", "a"})
+
+	want := []Event{
+		{Stage: StageParsed, Component: c, Args: []string{"subcomponent1", "a"}},
+		{Stage: StageMatched, Component: sub, Args: []string{"a"}},
+		{Stage: StageRun, Component: sub, Args: []string{"a"}},
+		{Stage: -1},
+		{Stage: StageDone, Component: sub, Args: []string{"a"}},
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("events = %v, want %v", events, want)
+	}
+}
+
+func TestComponent_OnEvent_Nested(t *testing.T) {
+	var stages []string
+	add := &Component{
+		UsageLine: "add",
+		Run:       func(context.Context, *Component, []string) {},
+	}
+	remote := &Component{
+		UsageLine:  "remote",
+		Components: []*Component{add},
+	}
+	c := &Component{
+		UsageLine:     UsageLine,
+		ErrorHandling: ContinueOnError,
+		Components:    []*Component{remote},
+		OnEvent: func(e Event) {
+			stages = append(stages, fmt.Sprintf("%v(%s)", e.Stage,
+				e.Component.Name()))
+		},
+	}
+
+	if err := Execute(context.Background(), c,
+		[]string{"remote", "add", "origin"}); nil != err {
+		t.Fatalf("Execute() error = %v, want nil", err)
+	}
+
+	want := []string{
+		"Parsed(test)",
+		"Matched(remote)",
+		"Parsed(remote)",
+		"Matched(add)",
+		"Run(add)",
+		"Done(add)",
+	}
+	if !reflect.DeepEqual(stages, want) {
+		t.Errorf("stages = %v, want %v", stages, want)
+	}
+}
+
+func TestStage_String(t *testing.T) {
+	tests := []struct {
+		stage Stage
+		want  string
+	}{
+		{StageParsed, "Parsed"},
+		{StageMatched, "Matched"},
+		{StageRun, "Run"},
+		{StageDone, "Done"},
+		{Stage(42), "Stage(42)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := tt.stage.String(); got != tt.want {
+				t.Errorf("Stage.String() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestComponent_EnabledFunc(t *testing.T) {
+	var enabled, ran bool
+	c := &Component{
+		UsageLine: UsageLine,
+		Run:       Passthrough,
+		Components: []*Component{
+			&Component{
+				UsageLine:   "subcomponent1",
+				Short:       "description of subcomponent 1",
+				EnabledFunc: func() bool { return enabled },
+				Run: func(context.Context, *Component, []string) {
+					ran = true
+				},
+			},
+		},
+	}
+
+	for _, enabled = range []bool{false, true} {
+		var buf bytes.Buffer
+		c.SetOutput(&buf)
+		c.Usage()
+		if got := strings.Contains(buf.String(), "subcomponent1"); got != enabled {
+			t.Errorf("subcomponent1 in usage = %v, want %v", got, enabled)
+		}
+
+		ran = false
+		c.Run(context.Background(), c, []string{"subcomponent1"})
+		if ran != enabled {
+			t.Errorf("subcomponent1 ran = %v, want %v", ran, enabled)
+		}
+	}
+}
+
+func TestComponent_GenCheatSheet(t *testing.T) {
+	add := &Component{UsageLine: "add"}
+	add.FlagSet().Bool("f", false, "fetch after adding")
+	add.FlagSet().String("t", "", "branch to track")
+	remote := &Component{
+		UsageLine:  "remote",
+		Components: []*Component{add, &Component{UsageLine: "remove"}},
+	}
+	root := &Component{
+		UsageLine:  UsageLine,
+		Components: []*Component{remote},
+	}
+	root.FlagSet().Bool("v", false, "verbose output")
+
+	var buf bytes.Buffer
+	root.GenCheatSheet(&buf)
+
+	want := `test  --v
+test remote
+test remote add  --f --t
+test remote remove
+`
+	if got := buf.String(); got != want {
+		t.Errorf("Component.GenCheatSheet() = %v, want %v", got, want)
+	}
+}
+
+func TestComponent_Experimental(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		env     string
+		wantRun bool
+		wantOut string
+	}{
+		{
+			name:    "Without Gate",
+			args:    []string{"subcomponent1"},
+			wantOut: "subcomponent1 is experimental; set TEST_EXPERIMENTAL=1 to enable it\n",
+		},
+		{
+			name:    "With Gate",
+			args:    []string{"subcomponent1"},
+			env:     "1",
+			wantRun: true,
+		},
+		{
+			name:    "Nested Without Gate",
+			args:    []string{"group", "subcomponent1"},
+			wantOut: "subcomponent1 is experimental; set TEST_EXPERIMENTAL=1 to enable it\n",
+		},
+		{
+			name:    "Nested With Gate",
+			args:    []string{"group", "subcomponent1"},
+			env:     "1",
+			wantRun: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer os.Unsetenv("TEST_EXPERIMENTAL")
+			os.Setenv("TEST_EXPERIMENTAL", tt.env)
+
+			var ran bool
+			newExperimental := func() *Component {
+				return &Component{
+					UsageLine:    "subcomponent1",
+					Experimental: true,
+					Run: func(context.Context, *Component, []string) {
+						ran = true
+					},
+				}
+			}
+			c := &Component{
+				UsageLine: UsageLine,
+				Run:       Passthrough,
+				Components: []*Component{
+					newExperimental(),
+					&Component{
+						UsageLine:  "group",
+						Run:        Passthrough,
+						Components: []*Component{newExperimental()},
+					},
+				},
+			}
+			var buf bytes.Buffer
+			c.SetOutput(&buf)
+
+			c.Run(context.Background(), c, tt.args)
+			if ran != tt.wantRun {
+				t.Errorf("subcomponent1 ran = %v, want %v", ran, tt.wantRun)
+			}
+			got := buf.String()
+			if "" == tt.wantOut {
+				if "" != got {
+					t.Errorf("output = %v, want none", got)
+				}
+			} else if !strings.HasPrefix(got, tt.wantOut+"Usage: test") {
+				t.Errorf("output = %v, want %v followed by usage", got, tt.wantOut)
+			}
+		})
+	}
+}
+
+func TestComponent_Experimental_Env(t *testing.T) {
+	tests := []struct {
+		name      string
+		usageLine string
+		program   string
+		want      string
+	}{
+		{
+			name:      "Dashes",
+			usageLine: "my-app",
+			want:      "MY_APP_EXPERIMENTAL",
+		},
+		{
+			name:      "Dots",
+			usageLine: "my.app v2",
+			want:      "MY_APP_EXPERIMENTAL",
+		},
+		{
+			name:    "Binary Name",
+			program: "tool",
+			want:    "TOOL_EXPERIMENTAL",
+		},
+		{
+			name:    "Binary Name With Extension",
+			program: "tool.exe",
+			want:    "TOOL_EXPERIMENTAL",
+		},
+	}
+
+	defer func(f func() string) { programName = f }(programName)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			programName = func() string {
+				return tt.program
+			}
+
+			c := &Component{
+				UsageLine:     tt.usageLine,
+				ErrorHandling: ContinueOnError,
+				Components: []*Component{
+					&Component{
+						UsageLine:    "subcomponent1",
+						Experimental: true,
+						Run:          func(context.Context, *Component, []string) {},
+					},
+				},
+			}
+
+			want := "subcomponent1 is experimental; set " + tt.want +
+				"=1 to enable it"
+			err := Execute(context.Background(), c, []string{"subcomponent1"})
+			if nil == err || err.Error() != want {
+				t.Errorf("Execute() error = %v, want %v", err, want)
+			}
+		})
+	}
+}
+
+func TestComponent_CompactFlags(t *testing.T) {
+	c := &Component{
+		UsageLine:    UsageLine,
+		Run:          Passthrough,
+		CompactFlags: true,
+	}
+	c.FlagSet().String("i", "", "input of the test component")
+	c.FlagSet().Int("timeout", 30, "number of seconds to wait for the "+
+		"remote server to respond before giving up on the request and "+
+		"reporting a failure")
+
+	var buf bytes.Buffer
+	c.SetOutput(&buf)
+	c.Usage()
+
+	want := `Usage: test [-i input]
+
+The flags are:
+  -i string     input of the test component
+  -timeout int  number of seconds to wait for the remote server to respond
+                before giving up on the request and reporting a failure (default
+                30)
+`
+	if got := buf.String(); got != want {
+		t.Errorf("Component.Usage() = %v, want %v", got, want)
+	}
+}
+
+func TestPassthrough_Terminator(t *testing.T) {
+	tests := []struct {
+		name         string
+		args         []string
+		wantFallback []string
+		wantGroup    []string
+	}{
+		{
+			name:         "Before Component",
+			args:         []string{"--", "group", "a"},
+			wantFallback: []string{"group", "a"},
+		},
+		{
+			name:      "After Component",
+			args:      []string{"group", "--", "-a"},
+			wantGroup: []string{"-a"},
+		},
+		{
+			name:      "Flag Value",
+			args:      []string{"-name", "--", "group", "x"},
+			wantGroup: []string{"x"},
+		},
+		{
+			name:         "After Flag Value",
+			args:         []string{"-name", "--", "--", "group", "x"},
+			wantFallback: []string{"group", "x"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotFallback, gotGroup []string
+			c := &Component{
+				UsageLine: UsageLine,
+				Run:       Passthrough,
+				Components: []*Component{
+					&Component{
+						UsageLine: "group",
+						Run: func(ctx context.Context, comp *Component,
+							args []string) {
+							comp.Parse(args)
+							gotGroup = comp.FlagSet().Args()
+						},
+					},
+				},
+				Fallback: func(ctx context.Context, comp *Component,
+					name string, args []string) {
+					gotFallback = append([]string{name}, args...)
+				},
+			}
+			c.FlagSet().String("name", "", "name of the test")
+
+			c.Run(context.Background(), c, tt.args)
+
+			if !reflect.DeepEqual(gotFallback, tt.wantFallback) {
+				t.Errorf("root args = %v, want %v", gotFallback, tt.wantFallback)
+			}
+			if !reflect.DeepEqual(gotGroup, tt.wantGroup) {
+				t.Errorf("group args = %v, want %v", gotGroup, tt.wantGroup)
+			}
+		})
+	}
+}
+
+func TestComponent_StringMap(t *testing.T) {
+	c := &Component{UsageLine: "run"}
+	labels := c.StringMap("label", "labels of the run")
+
+	if err := c.Parse([]string{"-label", "k1=v1", "--label", "k2=v2"}); nil != err {
+		t.Fatalf("Component.Parse() = %v, want nil", err)
+	}
+	want := map[string]string{"k1": "v1", "k2": "v2"}
+	if !reflect.DeepEqual(*labels, want) {
+		t.Errorf("labels = %v, want %v", *labels, want)
+	}
+
+	if err := c.FlagSet().Set("label", "k3"); nil == err {
+		t.Error("FlagSet().Set() = nil, want error for a malformed entry")
+	}
+}
+
+func TestComponent_MarkFlagGlobalOnly(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		wantRun bool
+		wantOut string
+	}{
+		{
+			name:    "Before Component",
+			args:    []string{"--config", "x", "sub"},
+			wantRun: true,
+		},
+		{
+			name:    "After Component",
+			args:    []string{"sub", "--config", "x"},
+			wantOut: "flag -config must be given before sub\n",
+		},
+		{
+			name:    "After Component With Value",
+			args:    []string{"sub", "-config=x"},
+			wantOut: "flag -config must be given before sub\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ran bool
+			c := &Component{
+				UsageLine: UsageLine,
+				Run:       Passthrough,
+				Components: []*Component{
+					&Component{
+						UsageLine: "sub",
+						Run: func(context.Context, *Component, []string) {
+							ran = true
+						},
+					},
+				},
+			}
+			c.FlagSet().String("config", "", "configuration file")
+			c.MarkFlagGlobalOnly("config")
+			var buf bytes.Buffer
+			c.SetOutput(&buf)
+
+			c.Run(context.Background(), c, tt.args)
+			if ran != tt.wantRun {
+				t.Errorf("sub ran = %v, want %v", ran, tt.wantRun)
+			}
+			got := buf.String()
+			if "" == tt.wantOut {
+				if "" != got {
+					t.Errorf("output = %v, want none", got)
+				}
+			} else if !strings.HasPrefix(got, tt.wantOut+"Usage: test") {
+				t.Errorf("output = %v, want %v followed by usage", got, tt.wantOut)
+			}
+		})
+	}
+}
+
+func TestComponent_AllFlags(t *testing.T) {
+	add := &Component{UsageLine: "add"}
+	add.FlagSet().Bool("f", false, "fetch after adding")
+	add.FlagSet().String("t", "", "branch to track")
+	root := &Component{
+		UsageLine: UsageLine,
+		Components: []*Component{
+			&Component{
+				UsageLine:  "remote",
+				Components: []*Component{add},
+			},
+		},
+	}
+	root.FlagSet().Bool("v", false, "verbose output")
+
+	want := map[string][]string{
+		"test":            {"v"},
+		"test remote":     {},
+		"test remote add": {"f", "t"},
+	}
+	if got := root.AllFlags(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Component.AllFlags() = %v, want %v", got, want)
+	}
+}
+
+func TestComponent_AddFlagNormalizer(t *testing.T) {
+	c := &Component{UsageLine: "build"}
+	dir := c.FlagSet().String("dir", "", "output directory")
+	c.AddFlagNormalizer("dir", filepath.Clean)
+
+	if err := c.Parse([]string{"-dir", "out//bin/../release/"}); nil != err {
+		t.Fatalf("Component.Parse() = %v, want nil", err)
+	}
+	if want := filepath.Clean("out/release"); *dir != want {
+		t.Errorf("dir = %v, want %v", *dir, want)
+	}
+}
+
+func TestComponent_ApplyDefaultsFromConfig(t *testing.T) {
+	c := &Component{
+		UsageLine: UsageLine,
+		Run:       Passthrough,
+	}
+	level := c.FlagSet().Int("level", 0, "log level")
+
+	var ran bool
+	var gotLevel int
+	c.Components = []*Component{
+		&Component{
+			UsageLine: "serve",
+			Run: func(context.Context, *Component, []string) {
+				ran = true
+				gotLevel = *level
+			},
+		},
+	}
+
+	config := `{"defaultCommand": "serve", "flags": {"level": 3}}`
+	if err := c.ApplyDefaultsFromConfig(strings.NewReader(config)); nil != err {
+		t.Fatalf("Component.ApplyDefaultsFromConfig() = %v, want nil", err)
+	}
+
+	c.Run(context.Background(), c, nil)
+	if !ran {
+		t.Error("serve did not run")
+	}
+	if gotLevel != 3 {
+		t.Errorf("level = %v, want %v", gotLevel, 3)
+	}
+
+	err := c.ApplyDefaultsFromConfig(strings.NewReader(`{"flags": {"x": 1}}`))
+	if nil == err {
+		t.Error("Component.ApplyDefaultsFromConfig() = nil, want error for undefined flag")
+	}
+}
+
+func TestComponent_PrintTree(t *testing.T) {
+	root := &Component{
+		UsageLine: UsageLine,
+		Short:     "test application",
+		Components: []*Component{
+			&Component{
+				UsageLine: "remote",
+				Short:     "manage remotes",
+				Components: []*Component{
+					&Component{
+						UsageLine: "add",
+						Short:     "add a remote",
+					},
+					&Component{
+						UsageLine:   "prune",
+						Short:       "prune a remote",
+						EnabledFunc: func() bool { return false },
+					},
+				},
+			},
+			&Component{
+				UsageLine: "status",
+				Short:     "show the status",
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	root.PrintTree(&buf)
+
+	want := `test - test application
+  remote - manage remotes
+    add - add a remote
+  status - show the status
+`
+	if got := buf.String(); got != want {
+		t.Errorf("Component.PrintTree() = %v, want %v", got, want)
+	}
+}
+
+func TestComponent_BindFlagFromFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "token")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("s3cr3t\n")
+	f.Close()
+
+	c := &Component{UsageLine: "login"}
+	token := c.FlagSet().String("token", "", "access token")
+	c.FlagSet().String("token-file", "", "file containing the access token")
+	c.BindFlagFromFile("token", "token-file")
+
+	if err := c.Parse([]string{"-token-file", f.Name()}); nil != err {
+		t.Fatalf("Component.Parse() = %v, want nil", err)
+	}
+	if *token != "s3cr3t" {
+		t.Errorf("token = %v, want %v", *token, "s3cr3t")
+	}
+
+	if err := c.Parse([]string{"-token-file", f.Name() + ".missing"}); nil == err {
+		t.Error("Component.Parse() = nil, want error for a missing file")
+	}
+}
+
+func TestComponent_FlagIndent(t *testing.T) {
+	c := &Component{
+		UsageLine:    UsageLine,
+		Run:          Passthrough,
+		CompactFlags: true,
+		FlagIndent:   4,
+	}
+	c.FlagSet().String("i", "", "input of the test component")
+	c.FlagSet().Bool("v", false, "verbose output")
+
+	var buf bytes.Buffer
+	c.SetOutput(&buf)
+	c.Usage()
+
+	want := `Usage: test [-i input]
+
+The flags are:
+    -i string  input of the test component
+    -v         verbose output
+`
+	if got := buf.String(); got != want {
+		t.Errorf("Component.Usage() = %v, want %v", got, want)
+	}
+}
+
+func TestComponent_AddFlagDefaultFunc(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{
+			name: "Computed",
+			args: []string{"-name", "app"},
+			want: "./app",
+		},
+		{
+			name: "Explicit",
+			args: []string{"-name", "app", "-output-dir", "/tmp/out"},
+			want: "/tmp/out",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Component{UsageLine: "new"}
+			c.FlagSet().String("name", "", "name of the project")
+			outputDir := c.FlagSet().String("output-dir", "", "output directory")
+			c.AddFlagDefaultFunc("output-dir", func(c *Component) string {
+				return "./" + c.FlagSet().Lookup("name").Value.String()
+			})
+
+			if err := c.Parse(tt.args); nil != err {
+				t.Fatalf("Component.Parse() = %v, want nil", err)
+			}
+			if *outputDir != tt.want {
+				t.Errorf("output-dir = %v, want %v", *outputDir, tt.want)
+			}
+		})
+	}
+}
+
+func TestComponent_BindFlagEnv(t *testing.T) {
+	tests := []struct {
+		name string
+		env  string
+		args []string
+		want int
+	}{
+		{name: "Default", want: 8080},
+		{name: "Environment", env: "9090", want: 9090},
+		{name: "Explicit", env: "9090", args: []string{"-port", "7070"}, want: 7070},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer os.Unsetenv("TOOL_PORT")
+			if "" != tt.env {
+				os.Setenv("TOOL_PORT", tt.env)
+			}
+
+			c := &Component{UsageLine: "serve"}
+			port := c.FlagSet().Int("port", 8080, "port to listen on")
+			c.BindFlagEnv("port", "TOOL_PORT")
+
+			if err := c.Parse(tt.args); nil != err {
+				t.Fatalf("Component.Parse() = %v, want nil", err)
+			}
+			if *port != tt.want {
+				t.Errorf("port = %v, want %v", *port, tt.want)
+			}
+		})
+	}
+}
+
+func TestComponent_FlagsAsEnv(t *testing.T) {
+	c := &Component{UsageLine: "plugin"}
+	c.FlagSet().String("output-dir", "", "output directory")
+	c.FlagSet().Bool("v", false, "verbose output")
+	c.FlagSet().Int("jobs", 4, "number of jobs")
+	if err := c.Parse([]string{"-output-dir", "/tmp/out", "-v"}); nil != err {
+		t.Fatalf("Component.Parse() = %v, want nil", err)
+	}
+
+	want := []string{"TOOL_JOBS=4", "TOOL_OUTPUT_DIR=/tmp/out", "TOOL_V=true"}
+	if got := c.FlagsAsEnv("TOOL"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Component.FlagsAsEnv() = %v, want %v", got, want)
+	}
+}
+
+func TestOutputFromContext(t *testing.T) {
+	c := &Component{
+		UsageLine:     "tool",
+		ErrorHandling: ContinueOnError,
+		Components: []*Component{
+			&Component{
+				UsageLine: "greet",
+				Run: func(ctx context.Context, comp *Component, args []string) {
+					fmt.Fprintln(OutputFromContext(ctx), args[0])
+				},
+			},
+		},
+	}
+
+	for _, name := range []string{"alice", "bob"} {
+		var buf bytes.Buffer
+		ctx := WithOutput(context.Background(), &buf)
+		if err := Execute(ctx, c, []string{"greet", name}); nil != err {
+			t.Fatalf("Execute() error = %v, want nil", err)
+		}
+		if got, want := buf.String(), name+"\n"; got != want {
+			t.Errorf("output of %v = %v, want %v", name, got, want)
+		}
+	}
+
+	if got := OutputFromContext(context.Background()); got != os.Stdout {
+		t.Errorf("OutputFromContext() = %v, want os.Stdout", got)
+	}
+}
+
+func TestComponent_RunExpectSilent(t *testing.T) {
+	c := &Component{
+		UsageLine: "tool",
+		Run:       Passthrough,
+		Components: []*Component{
+			&Component{
+				UsageLine: "sync",
+				Run:       func(context.Context, *Component, []string) {},
+			},
+			&Component{
+				UsageLine: "status",
+				Run: func(ctx context.Context, comp *Component, args []string) {
+					fmt.Fprintln(OutputFromContext(ctx), "up to date")
+				},
+			},
+		},
+	}
+
+	if err := c.RunExpectSilent(context.Background(), "sync"); nil != err {
+		t.Errorf("Component.RunExpectSilent(sync) = %v, want nil", err)
+	}
+	want := `tool wrote to the output: "up to date\n"`
+	if err := c.RunExpectSilent(context.Background(), "status"); nil == err ||
+		err.Error() != want {
+		t.Errorf("Component.RunExpectSilent(status) = %v, want %v", err, want)
+	}
+}
+
+func TestComponent_ChildFlagSet(t *testing.T) {
+	var gotRegion string
+	grandchild := &Component{
+		UsageLine: "add",
+		Run: func(ctx context.Context, comp *Component, args []string) {
+			gotRegion = comp.Parent().FlagSet().Lookup("region").Value.String()
+		},
+	}
+	child := &Component{
+		UsageLine:  "remote",
+		Components: []*Component{grandchild},
+		Run:        Passthrough,
+	}
+	root := &Component{
+		UsageLine:  UsageLine,
+		Run:        Passthrough,
+		Components: []*Component{child},
+	}
+	region := root.ChildFlagSet().String("region", "", "region to operate in")
+
+	root.Run(context.Background(), root,
+		[]string{"remote", "-region", "eu", "add"})
+
+	if *region != "eu" || gotRegion != "eu" {
+		t.Errorf("region = %v, %v, want %v", *region, gotRegion, "eu")
+	}
+	if nil != grandchild.FlagSet().Lookup("region") {
+		t.Error("grandchild inherited -region, want it not to")
+	}
+
+	want := map[string][]string{
+		"test":            {},
+		"test remote":     {"region"},
+		"test remote add": {},
+	}
+	if got := root.AllFlags(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Component.AllFlags() = %v, want %v", got, want)
+	}
+}
+
+func TestComponent_Usage_ContainerWithoutFlags(t *testing.T) {
+	c := &Component{
+		UsageLine: UsageLine,
+		Run:       Passthrough,
+		Components: []*Component{
+			&Component{
+				UsageLine: "subcomponent1",
+				Short:     "description of subcomponent 1",
+				Run:       func(context.Context, *Component, []string) {},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	c.SetOutput(&buf)
+	c.Usage()
+
+	want := `Usage: test [-i input]
+
+The components are:
+  subcomponent1 description of subcomponent 1
+`
+	if got := buf.String(); got != want {
+		t.Errorf("Component.Usage() = %v, want %v", got, want)
+	}
+}
+
+func TestComponent_LoadConfigYAML(t *testing.T) {
+	c := &Component{UsageLine: "serve"}
+	port := c.FlagSet().Int("port", 80, "port to listen on")
+	name := c.FlagSet().String("name", "", "name of the server")
+	debug := c.FlagSet().Bool("debug", false, "debug mode")
+
+	config := "# server configuration\n" +
+		"port: 8080 \t\n" +
+		"name: \"my app\" # quoted\n" +
+		"debug: true\n"
+	if err := c.LoadConfigYAML(strings.NewReader(config)); nil != err {
+		t.Fatalf("Component.LoadConfigYAML() = %v, want nil", err)
+	}
+	if err := c.Parse([]string{"-port", "9090"}); nil != err {
+		t.Fatalf("Component.Parse() = %v, want nil", err)
+	}
+
+	if *port != 9090 || *name != "my app" || !*debug {
+		t.Errorf("port, name, debug = %v, %v, %v, want %v, %v, %v",
+			*port, *name, *debug, 9090, "my app", true)
+	}
+
+	for _, tt := range []struct {
+		config string
+		want   string
+	}{
+		{"server:\n  port: 1\n", "line 1: "},
+		{"  port: 1\n", "line 1: only flat mappings are supported"},
+		{"\tport: 1\n", "line 1: only flat mappings are supported"},
+		{"- port\n", "line 1: only flat mappings are supported"},
+		{"port 1\n", "line 1: expected name: value"},
+		{"x: 1\n", "line 1: "},
+	} {
+		err := c.LoadConfigYAML(strings.NewReader(tt.config))
+		if nil == err || !strings.HasPrefix(err.Error(), tt.want) {
+			t.Errorf("Component.LoadConfigYAML(%q) = %v, want %v", tt.config,
+				err, tt.want)
+		}
+	}
+}
+
+// errNoRemote is returned by the PreRun hook of the nested component in
+// TestExecute
+var errNoRemote = errors.New("no remote")
+
+func TestExecute(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want error
+	}{
+		{
+			name: "Success",
+			args: []string{"subcomponent1"},
+		},
+		{
+			name: "No Command",
+			args: nil,
+			want: ErrNoCommand,
+		},
+		{
+			name: "Unknown Command",
+			args: []string{"subcomponent2", "a"},
+			want: ErrUnknownCommand{
+				Name:        "subcomponent2",
+				Suggestions: []string{"subcomponent1"},
+			},
+		},
+		{
+			name: "Nested Success",
+			args: []string{"remote", "add"},
+		},
+		{
+			name: "Unknown Nested Command",
+			args: []string{"remote", "bogus"},
+			want: ErrUnknownCommand{Name: "bogus"},
+		},
+		{
+			name: "Nested PreRun Error",
+			args: []string{"remote", "rm"},
+			want: errNoRemote,
+		},
+		{
+			name: "Nested Success Under Wrapped Passthrough",
+			args: []string{"wrapped", "add"},
+		},
+		{
+			name: "Unknown Command Under Wrapped Passthrough",
+			args: []string{"wrapped", "bogus"},
+			want: ErrUnknownCommand{Name: "bogus"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			run := func(context.Context, *Component, []string) {}
+			c := &Component{
+				UsageLine: UsageLine,
+				Run:       Passthrough,
+				Components: []*Component{
+					&Component{
+						UsageLine: "subcomponent1",
+						Run:       run,
+					},
+					&Component{
+						UsageLine: "remote",
+						Run:       Passthrough,
+						Components: []*Component{
+							&Component{UsageLine: "add", Run: run},
+							&Component{
+								UsageLine: "rm",
+								Run:       run,
+								PreRun: func(context.Context, *Component, []string) error {
+									return errNoRemote
+								},
+							},
+						},
+					},
+					&Component{
+						UsageLine: "wrapped",
+						Run: func(ctx context.Context, c *Component, args []string) {
+							Passthrough(ctx, c, args)
+						},
+						Components: []*Component{
+							&Component{UsageLine: "add", Run: run},
+						},
+					},
+				},
+			}
+			var buf bytes.Buffer
+			c.SetOutput(&buf)
+
+			if got := Execute(context.Background(), c, tt.args); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Execute() = %v, want %v", got, tt.want)
+			}
+			if got := buf.String(); "" != got {
+				t.Errorf("output = %v, want none", got)
+			}
+		})
+	}
+}
+
+func TestPassthrough_Recursive(t *testing.T) {
+	tests := []struct {
+		name      string
+		args      []string
+		wantArgs  []string
+		wantUsage string
+	}{
+		{
+			name:     "Nested",
+			args:     []string{"remote", "add", "origin", "url"},
+			wantArgs: []string{"origin", "url"},
+		},
+		{
+			name:      "Unknown Nested",
+			args:      []string{"remote", "rename"},
+			wantUsage: "The components are:\n  add",
+		},
+		{
+			name:      "Missing Nested",
+			args:      []string{"remote"},
+			wantUsage: "The components are:\n  add",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotArgs []string
+			c := &Component{
+				UsageLine: UsageLine,
+				Run:       Passthrough,
+				Components: []*Component{
+					&Component{
+						UsageLine: "remote",
+						Short:     "manage remotes",
+						Components: []*Component{
+							&Component{
+								UsageLine: "add",
+								Short:     "add a remote",
+								Run: func(ctx context.Context,
+									comp *Component, args []string) {
+									gotArgs = args
+								},
+							},
+						},
+					},
+				},
+			}
+			var buf bytes.Buffer
+			c.SetOutput(&buf)
+
+			c.Run(context.Background(), c, tt.args)
+
+			if !reflect.DeepEqual(gotArgs, tt.wantArgs) {
+				t.Errorf("add args = %v, want %v", gotArgs, tt.wantArgs)
+			}
+			got := buf.String()
+			if "" == tt.wantUsage && "" != got || !strings.HasPrefix(got, tt.wantUsage) {
+				t.Errorf("output = %v, want usage of remote", got)
+			}
+		})
+	}
+}
+
+func TestComponent_Aliases(t *testing.T) {
+	var ran string
+	run := func(ctx context.Context, comp *Component, args []string) {
+		ran = comp.Name()
+	}
+	c := &Component{
+		UsageLine: UsageLine,
+		Run:       Passthrough,
+		Components: []*Component{
+			&Component{
+				UsageLine: "remove",
+				Short:     "remove files",
+				Aliases:   []string{"rm", "del"},
+				Run:       run,
+			},
+			&Component{
+				UsageLine: "delete",
+				Short:     "delete branches",
+				Aliases:   []string{"del"},
+				Run:       run,
+			},
+		},
+	}
+	var buf bytes.Buffer
+	c.SetOutput(&buf)
+
+	for _, tt := range []struct {
+		name string
+		want string
+	}{
+		{"remove", "remove"},
+		{"rm", "remove"},
+		{"del", "remove"},
+		{"delete", "delete"},
+		{"RM", ""},
+	} {
+		ran = ""
+		c.Run(context.Background(), c, []string{tt.name})
+		if ran != tt.want {
+			t.Errorf("%v ran %v, want %v", tt.name, ran, tt.want)
+		}
+	}
+
+	buf.Reset()
+	c.Usage()
+	want := `Usage: test [-i input]
+
+The components are:
+  remove (rm, del) remove files
+  delete (del) delete branches
+`
+	if got := buf.String(); got != want {
+		t.Errorf("Component.Usage() = %v, want %v", got, want)
+	}
+}
+
+func TestPassthrough_Suggestions(t *testing.T) {
+	tests := []struct {
+		name string
+		arg  string
+		want []string
+	}{
+		{
+			name: "Close",
+			arg:  "comit",
+			want: []string{"commit"},
+		},
+		{
+			name: "Alias",
+			arg:  "ch",
+			want: []string{"co"},
+		},
+		{
+			name: "Far",
+			arg:  "xyz",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			run := func(context.Context, *Component, []string) {}
+			c := &Component{
+				UsageLine: UsageLine,
+				Run:       Passthrough,
+				Components: []*Component{
+					&Component{UsageLine: "commit", Run: run},
+					&Component{UsageLine: "checkout", Aliases: []string{"co"}, Run: run},
+					&Component{UsageLine: "status", Run: run},
+				},
+			}
+			var buf bytes.Buffer
+			c.SetOutput(&buf)
+
+			err := Execute(context.Background(), c, []string{tt.arg})
+			e, ok := err.(ErrUnknownCommand)
+			if !ok || !reflect.DeepEqual(e.Suggestions, tt.want) {
+				t.Fatalf("Execute() = %#v, want suggestions %v", err, tt.want)
+			}
+
+			c.Run(context.Background(), c, []string{tt.arg})
+			got := strings.HasPrefix(buf.String(), "Did you mean: ")
+			if got != (0 != len(tt.want)) {
+				t.Errorf("output = %v, want suggestions %v", buf.String(), tt.want)
+			}
+			if 0 != len(tt.want) && !strings.HasPrefix(buf.String(),
+				"Did you mean: "+tt.want[0]+"?\nUsage: test") {
+				t.Errorf("output = %v, want suggestion %v", buf.String(), tt.want[0])
+			}
+		})
+	}
+}
+
+func TestComponent_LintDocs(t *testing.T) {
+	run := func(context.Context, *Component, []string) {}
+	c := &Component{
+		UsageLine: UsageLine,
+		Run:       Passthrough,
+		Components: []*Component{
+			&Component{
+				UsageLine: "remote",
+				Components: []*Component{
+					&Component{UsageLine: "add", Short: "add a remote", Run: run},
+					&Component{UsageLine: "remove", Run: run},
+				},
+			},
+		},
+	}
+
+	errs := c.LintDocs()
+	if len(errs) != 1 {
+		t.Fatalf("Component.LintDocs() = %v, want 1 error", errs)
+	}
+	if want := "test remote remove: missing Short description"; errs[0].Error() != want {
+		t.Errorf("Component.LintDocs() = %v, want %v", errs[0], want)
+	}
+}
+
+func TestComponent_LintFlags(t *testing.T) {
+	run := func(context.Context, *Component, []string) {}
+	add := &Component{UsageLine: "add", Short: "add a remote", Run: run}
+	add.FlagSet().Int("region", 0, "index of the region")
+	add.FlagSet().Bool("f", false, "force")
+	remove := &Component{UsageLine: "remove", Short: "remove a remote", Run: run}
+	c := &Component{
+		UsageLine: "tool",
+		Run:       Passthrough,
+		Components: []*Component{
+			&Component{
+				UsageLine:  "remote",
+				Components: []*Component{add, remove},
+			},
+		},
+	}
+	c.PersistentFlagSet().String("region", "eu", "region of the remote")
+
+	// Inherited flags share the value of the persistent flag, and are not
+	// reported
+	c.Run(context.Background(), c, []string{"remote", "remove"})
+
+	errs := c.LintFlags()
+	if len(errs) != 1 {
+		t.Fatalf("Component.LintFlags() = %v, want 1 error", errs)
+	}
+	if want := "tool remote add: flag -region shadows the persistent flag of tool"; errs[0].Error() != want {
+		t.Errorf("Component.LintFlags() = %v, want %v", errs[0], want)
+	}
+}
+
+func TestComponent_Merge(t *testing.T) {
+	var ran []string
+	leaf := func(name string, aliases ...string) *Component {
+		return &Component{
+			UsageLine: name,
+			Aliases:   aliases,
+			Run: func(context.Context, *Component, []string) {
+				ran = append(ran, name)
+			},
+		}
+	}
+	root := &Component{
+		UsageLine:  "tool",
+		Run:        Passthrough,
+		Components: []*Component{leaf("build"), leaf("test")},
+	}
+	plugin := &Component{
+		UsageLine:  "plugin",
+		Components: []*Component{leaf("deploy", "d"), leaf("status")},
+	}
+
+	if err := root.Merge(plugin); nil != err {
+		t.Fatalf("Component.Merge() = %v, want nil", err)
+	}
+	for _, name := range []string{"build", "test", "deploy", "d", "status"} {
+		if err := Execute(context.Background(), root, []string{name}); nil != err {
+			t.Errorf("Execute(%v) = %v, want nil", name, err)
+		}
+	}
+	want := []string{"build", "test", "deploy", "deploy", "status"}
+	if !reflect.DeepEqual(ran, want) {
+		t.Errorf("ran = %v, want %v", ran, want)
+	}
+
+	conflicting := &Component{
+		UsageLine:  "other",
+		Components: []*Component{leaf("lint"), leaf("debug", "d")},
+	}
+	wantErr := "cannot merge other into tool: d already names a component"
+	if err := root.Merge(conflicting); nil == err || err.Error() != wantErr {
+		t.Errorf("Component.Merge() = %v, want %v", err, wantErr)
+	}
+	if 4 != len(root.Components) {
+		t.Errorf("Component.Merge() merged %v, want no components",
+			root.Components[4:])
+	}
+}
+
+func TestComponent_FullName(t *testing.T) {
+	var gotParent *Component
+	var gotFullName string
+	add := &Component{
+		UsageLine: "add name url",
+		Run: func(ctx context.Context, comp *Component, args []string) {
+			gotParent = comp.Parent()
+			gotFullName = comp.FullName()
+		},
+	}
+	remote := &Component{
+		UsageLine:  "remote",
+		Components: []*Component{add},
+	}
+	root := &Component{
+		UsageLine:  "tool",
+		Run:        Passthrough,
+		Components: []*Component{remote},
+	}
+
+	if got := root.FullName(); got != "tool" {
+		t.Errorf("Component.FullName() = %v, want %v", got, "tool")
+	}
+
+	root.Run(context.Background(), root, []string{"remote", "add", "origin", "url"})
+
+	if nil != root.Parent() {
+		t.Errorf("root Component.Parent() = %v, want nil", root.Parent())
+	}
+	if gotParent != remote {
+		t.Errorf("Component.Parent() = %v, want %v", gotParent, remote)
+	}
+	if want := "tool remote add"; gotFullName != want {
+		t.Errorf("Component.FullName() = %v, want %v", gotFullName, want)
+	}
+
+	var buf bytes.Buffer
+	add.SetOutput(&buf)
+	add.Usage()
+	if got, want := buf.String(), "Usage: tool remote add name url\n"; got != want {
+		t.Errorf("Component.Usage() = %v, want %v", got, want)
+	}
+}
+
+func TestComponent_EnableConfigFlag(t *testing.T) {
+	f, err := ioutil.TempFile("", "config")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{"flags": {"host": "example.com", "port": 8080}}`)
+	f.Close()
+
+	c := &Component{
+		UsageLine:        "serve",
+		EnableConfigFlag: true,
+	}
+	host := c.FlagSet().String("host", "localhost", "host to listen on")
+	port := c.FlagSet().Int("port", 80, "port to listen on")
+
+	if err := c.Parse([]string{"-port", "9090", "-config", f.Name()}); nil != err {
+		t.Fatalf("Component.Parse() = %v, want nil", err)
+	}
+	if *host != "example.com" {
+		t.Errorf("host = %v, want %v", *host, "example.com")
+	}
+	if *port != 9090 {
+		t.Errorf("port = %v, want %v", *port, 9090)
+	}
+
+	// The flag is added even if EnableConfigFlag is set once the flags
+	// exist
+	var gotHost string
+	root := &Component{
+		UsageLine: "tool",
+		Run:       Passthrough,
+		Components: []*Component{
+			&Component{
+				UsageLine: "status",
+				Run: func(context.Context, *Component, []string) {
+					gotHost = *host
+				},
+			},
+		},
+	}
+	host = root.FlagSet().String("host", "localhost", "host to connect to")
+	root.FlagSet().Int("port", 80, "port to connect to")
+	root.SetOutput(ioutil.Discard)
+	root.EnableConfigFlag = true
+
+	err = Execute(context.Background(), root,
+		[]string{"-config", f.Name(), "status"})
+	if nil != err {
+		t.Fatalf("Execute() = %v, want nil", err)
+	}
+	if gotHost != "example.com" {
+		t.Errorf("host = %v, want %v", gotHost, "example.com")
+	}
+}
+
+func TestComponent_EnableConfigFlag_Nested(t *testing.T) {
+	f, err := ioutil.TempFile("", "config")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{"flags": {"region": "eu", "port": 9090, "env": "prod"}}`)
+	f.Close()
+
+	tests := []struct {
+		name       string
+		args       []string
+		wantRegion string
+		wantPort   string
+		wantEnv    string
+	}{
+		{
+			name:       "Leaf Parsing Its Flags",
+			args:       []string{"-config", f.Name(), "serve"},
+			wantRegion: "eu",
+			wantPort:   "9090",
+		},
+		{
+			name:       "Leaf Flag Overrides",
+			args:       []string{"-config", f.Name(), "serve", "-port", "1"},
+			wantRegion: "eu",
+			wantPort:   "1",
+		},
+		{
+			name:       "Persistent Flag Overrides",
+			args:       []string{"-region", "us", "-config", f.Name(), "serve"},
+			wantRegion: "us",
+			wantPort:   "9090",
+		},
+		{
+			name:       "Given To The Leaf",
+			args:       []string{"serve", "-config", f.Name()},
+			wantRegion: "eu",
+			wantPort:   "9090",
+		},
+		{
+			name:       "Leaf With Args",
+			args:       []string{"-config", f.Name(), "deploy", "app"},
+			wantRegion: "eu",
+			wantEnv:    "prod",
+		},
+		{
+			name:       "Leaf With Args Flag Overrides",
+			args:       []string{"-config", f.Name(), "deploy", "-env", "dev", "app"},
+			wantRegion: "eu",
+			wantEnv:    "dev",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotRegion, gotPort, gotEnv string
+			serve := &Component{
+				UsageLine: "serve",
+				Run: func(ctx context.Context, comp *Component, args []string) {
+					comp.Parse(args)
+					gotRegion = comp.FlagSet().Lookup("region").Value.String()
+					gotPort = comp.FlagSet().Lookup("port").Value.String()
+				},
+			}
+			serve.FlagSet().Int("port", 80, "port to listen on")
+			deploy := &Component{
+				UsageLine: "deploy",
+				Args:      ExactArgs(1),
+				Run: func(ctx context.Context, comp *Component, args []string) {
+					gotRegion = comp.FlagSet().Lookup("region").Value.String()
+					gotEnv = comp.FlagSet().Lookup("env").Value.String()
+				},
+			}
+			deploy.FlagSet().String("env", "staging", "environment to deploy to")
+			root := &Component{
+				UsageLine:        "tool",
+				ErrorHandling:    ContinueOnError,
+				EnableConfigFlag: true,
+				Components:       []*Component{serve, deploy},
+			}
+			root.PersistentFlagSet().String("region", "", "region to operate in")
+			root.SetOutput(ioutil.Discard)
+
+			if err := Execute(context.Background(), root, tt.args); nil != err {
+				t.Fatalf("Execute() = %v, want nil", err)
+			}
+			if gotRegion != tt.wantRegion || gotPort != tt.wantPort ||
+				gotEnv != tt.wantEnv {
+				t.Errorf("region, port, env = %v, %v, %v, want %v, %v, %v",
+					gotRegion, gotPort, gotEnv, tt.wantRegion, tt.wantPort,
+					tt.wantEnv)
+			}
+		})
+	}
+}
+
+func TestExecute_HelpShort(t *testing.T) {
+	rootUsage := `Usage: test [-i input]
+test application
+
+The components are: subcomponent1 subcomponent2
+`
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{
+			name: "Root",
+			args: []string{"-i", "in", "--help-short", "subcomponent1"},
+			want: rootUsage,
+		},
+		{
+			name: "After Persistent Flag",
+			args: []string{"-region", "us", "-help-short", "subcomponent1"},
+			want: rootUsage,
+		},
+		{
+			name: "Leaf",
+			args: []string{"subcomponent1", "-v", "-help-short"},
+			want: "Usage: test subcomponent1\ndescription of subcomponent 1\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ran bool
+			sub := &Component{
+				UsageLine: "subcomponent1",
+				Short:     "description of subcomponent 1",
+				Run: func(context.Context, *Component, []string) {
+					ran = true
+				},
+			}
+			sub.FlagSet().Bool("v", false, "verbose output")
+			c := &Component{
+				UsageLine: UsageLine,
+				Short:     "test application",
+				Long:      Long,
+				Run:       Passthrough,
+				Components: []*Component{
+					sub,
+					&Component{
+						UsageLine: "subcomponent2",
+						Run:       func(context.Context, *Component, []string) {},
+					},
+				},
+			}
+			c.FlagSet().String("i", "", "input of the test component")
+			c.PersistentFlagSet().String("region", "", "region to operate in")
+			var buf bytes.Buffer
+			c.SetOutput(&buf)
+
+			err := Execute(context.Background(), c, tt.args)
+			if err != ErrHelp {
+				t.Errorf("Execute() = %v, want %v", err, ErrHelp)
+			}
+			if ran {
+				t.Error("subcomponent1 ran, want it not to")
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("output = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHelpComponent(t *testing.T) {
+	add := &Component{
+		UsageLine: "add name url",
+		Short:     "add a remote",
+		Run:       func(context.Context, *Component, []string) {},
+	}
+	root := &Component{
+		UsageLine: UsageLine,
+		Run:       Passthrough,
+		Components: []*Component{
+			&Component{
+				UsageLine:  "remote",
+				Short:      "manage remotes",
+				Components: []*Component{add},
+			},
+		},
+	}
+	root.Components = append(root.Components, HelpComponent(root))
+	root.PersistentFlagSet().String("region", "", "region to operate in")
+	root.inheritPersistentFlags()
+
+	var rootUsage bytes.Buffer
+	root.SetOutput(&rootUsage)
+	root.Usage()
+
+	// The usage of add shows its full name and the flags it inherits
+	addUsage := `Usage: test remote add name url
+
+Global flags from test:
+  -region string
+    	region to operate in
+`
+
+	tests := []struct {
+		name     string
+		args     []string
+		want     string
+		wantErr  error
+		wantCode int
+	}{
+		{
+			name: "No Arguments",
+			args: []string{"help"},
+			want: rootUsage.String(),
+		},
+		{
+			name: "Nested",
+			args: []string{"help", "remote", "add"},
+			want: addUsage,
+		},
+		{
+			name:     "Unknown",
+			args:     []string{"help", "remote", "rename"},
+			want:     "unknown command: rename\n" + rootUsage.String(),
+			wantErr:  ErrUnknownCommand{Name: "rename"},
+			wantCode: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			root.SetOutput(&buf)
+
+			root.Run(context.Background(), root, tt.args)
+			if got := buf.String(); got != tt.want {
+				t.Errorf("output = %v, want %v", got, tt.want)
+			}
+
+			buf.Reset()
+			err := Execute(context.Background(), root, tt.args)
+			if !reflect.DeepEqual(err, tt.wantErr) {
+				t.Errorf("Execute() error = %v, want %v", err, tt.wantErr)
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("Execute() output = %v, want %v", got, tt.want)
+			}
+
+			buf.Reset()
+			if got := RunMain(root, tt.args); got != tt.wantCode {
+				t.Errorf("RunMain() = %v, want %v", got, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestComponent_RunWithEnv(t *testing.T) {
+	os.Setenv("TEST_REGION", "us")
+	defer os.Unsetenv("TEST_REGION")
+	os.Unsetenv("TEST_PROFILE")
+
+	var gotRegion, gotProfile string
+	c := &Component{
+		UsageLine: UsageLine,
+		Run:       Passthrough,
+		Components: []*Component{
+			&Component{
+				UsageLine: "deploy",
+				Run: func(context.Context, *Component, []string) {
+					gotRegion = os.Getenv("TEST_REGION")
+					gotProfile = os.Getenv("TEST_PROFILE")
+				},
+			},
+		},
+	}
+
+	err := c.RunWithEnv(context.Background(), map[string]string{
+		"TEST_REGION":  "eu",
+		"TEST_PROFILE": "staging",
+	}, []string{"deploy"})
+	if nil != err {
+		t.Fatalf("Component.RunWithEnv() = %v, want nil", err)
+	}
+
+	if gotRegion != "eu" || gotProfile != "staging" {
+		t.Errorf("environment = %v, %v, want %v, %v", gotRegion, gotProfile,
+			"eu", "staging")
+	}
+	if got := os.Getenv("TEST_REGION"); got != "us" {
+		t.Errorf("TEST_REGION = %v after run, want %v", got, "us")
+	}
+	if _, ok := os.LookupEnv("TEST_PROFILE"); ok {
+		t.Error("TEST_PROFILE set after run, want it unset")
+	}
+}
+
+func TestVersionComponent(t *testing.T) {
+	tests := []struct {
+		name    string
+		version *Component
+		want    string
+	}{
+		{
+			name:    "Full",
+			version: VersionComponent("v1.2.3", "abc123", "2024-01-02"),
+			want:    "tool version v1.2.3 (abc123, built 2024-01-02)\n",
+		},
+		{
+			name:    "Without Commit",
+			version: VersionComponent("v1.2.3", "", "2024-01-02"),
+			want:    "tool version v1.2.3 (built 2024-01-02)\n",
+		},
+		{
+			name:    "Version Only",
+			version: VersionComponent("v1.2.3", "", ""),
+			want:    "tool version v1.2.3\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := &Component{
+				UsageLine:  "tool",
+				Run:        Passthrough,
+				Components: []*Component{tt.version},
+			}
+			var buf bytes.Buffer
+			root.SetOutput(&buf)
+
+			root.Run(context.Background(), root, []string{"version"})
+			if got := buf.String(); got != tt.want {
+				t.Errorf("output = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestComponent_FlagDefaults(t *testing.T) {
+	c := &Component{UsageLine: "serve"}
+	c.FlagSet().String("host", "localhost", "host to listen on")
+	c.FlagSet().Int("port", 80, "port to listen on")
+	c.FlagSet().Bool("debug", false, "debug mode")
+	c.FlagSet().Duration("timeout", 30*time.Second, "request timeout")
+
+	c.Parse([]string{"-port", "8080"})
+
+	want := map[string]string{
+		"host":    "localhost",
+		"port":    "80",
+		"debug":   "false",
+		"timeout": "30s",
+	}
+	if got := c.FlagDefaults(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Component.FlagDefaults() = %v, want %v", got, want)
+	}
+}
+
+func TestComponent_ErrorHandling(t *testing.T) {
+	c := &Component{
+		UsageLine:     UsageLine,
+		Run:           Passthrough,
+		ErrorHandling: ContinueOnError,
+	}
+	var buf bytes.Buffer
+	c.SetOutput(&buf)
+
+	err := Execute(context.Background(), c, []string{"-unknown"})
+	if want := "flag provided but not defined: -unknown"; nil == err || err.Error() != want {
+		t.Errorf("Execute() = %v, want %v", err, want)
+	}
+
+	buf.Reset()
+	c.Run(context.Background(), c, []string{"-unknown"})
+	if got := buf.String(); 1 != strings.Count(got, "flag provided but not defined") ||
+		1 != strings.Count(got, "Usage: test") {
+		t.Errorf("output = %v, want the error and usage once", got)
+	}
+}
+
+func TestComponent_PersistentFlagSet(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{
+			name: "On Root",
+			args: []string{"-v", "remote", "add", "origin"},
+		},
+		{
+			name: "On Grandchild",
+			args: []string{"remote", "add", "-v", "origin"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotArgs []string
+			add := &Component{
+				UsageLine: "add",
+				Run: func(ctx context.Context, comp *Component, args []string) {
+					comp.Parse(args)
+					gotArgs = comp.FlagSet().Args()
+				},
+			}
+			root := &Component{
+				UsageLine: "tool",
+				Run:       Passthrough,
+				Components: []*Component{
+					&Component{
+						UsageLine:  "remote",
+						Components: []*Component{add},
+					},
+				},
+			}
+			verbose := root.PersistentFlagSet().Bool("v", false, "verbose output")
+
+			root.Run(context.Background(), root, tt.args)
+
+			if !*verbose {
+				t.Error("v = false, want true")
+			}
+			if want := []string{"origin"}; !reflect.DeepEqual(gotArgs, want) {
+				t.Errorf("add args = %v, want %v", gotArgs, want)
+			}
+			if got := root.AllFlags()["tool remote add"]; !reflect.DeepEqual(got,
+				[]string{"v"}) {
+				t.Errorf("Component.AllFlags() = %v, want %v", got, []string{"v"})
+			}
+		})
+	}
+}
+
+func TestComponent_PersistentFlagSet_ChildWins(t *testing.T) {
+	var childVerbose *string
+	child := &Component{
+		UsageLine: "child",
+		Run: func(ctx context.Context, comp *Component, args []string) {
+			comp.Parse(args)
+		},
+	}
+	childVerbose = child.FlagSet().String("v", "", "verbosity level")
+	root := &Component{
+		UsageLine:  "tool",
+		Run:        Passthrough,
+		Components: []*Component{child},
+	}
+	verbose := root.PersistentFlagSet().Bool("v", false, "verbose output")
+
+	root.Run(context.Background(), root, []string{"child", "-v", "debug"})
+
+	if *verbose || *childVerbose != "debug" {
+		t.Errorf("v = %v, %v, want %v, %v", *verbose, *childVerbose, false, "debug")
+	}
+
+	want := map[string][]string{
+		"tool":       {"v"},
+		"tool child": {"v"},
+	}
+	if got := root.AllFlags(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Component.AllFlags() = %v, want %v", got, want)
+	}
+}
+
+func TestComponent_OnResolved(t *testing.T) {
+	var calls int
+	var gotComp *Component
+	var gotArgs []string
+	add := &Component{
+		UsageLine: "add",
+		Run:       func(context.Context, *Component, []string) {},
+	}
+	root := &Component{
+		UsageLine: "tool",
+		Run:       Passthrough,
+		Components: []*Component{
+			&Component{
+				UsageLine:  "remote",
+				Run:        Passthrough,
+				Components: []*Component{add},
+			},
+		},
+		OnResolved: func(comp *Component, args []string) {
+			calls++
+			gotComp = comp
+			gotArgs = args
+		},
+	}
+
+	root.Run(context.Background(), root, []string{"remote", "add", "-f", "origin"})
+
+	if calls != 1 {
+		t.Errorf("OnResolved called %v times, want 1", calls)
+	}
+	if gotComp != add {
+		t.Errorf("OnResolved component = %v, want %v", gotComp, add)
+	}
+	if want := []string{"-f", "origin"}; !reflect.DeepEqual(gotArgs, want) {
+		t.Errorf("OnResolved args = %v, want %v", gotArgs, want)
+	}
+}
+
+func TestComponent_PreRunPostRun(t *testing.T) {
+	tests := []struct {
+		name      string
+		preRunErr error
+		want      []string
+	}{
+		{
+			name: "Success",
+			want: []string{
+				"tool pre add", "remote pre add", "add pre add",
+				"add run",
+				"add post add", "remote post add", "tool post add",
+			},
+		},
+		{
+			name:      "PreRun Error",
+			preRunErr: errors.New("no database"),
+			want: []string{
+				"tool pre add", "remote pre add",
+				"add post add", "remote post add", "tool post add",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls []string
+			hook := func(name, stage string, err error) func(context.Context,
+				*Component, []string) error {
+				return func(ctx context.Context, comp *Component, args []string) error {
+					calls = append(calls, name+" "+stage+" "+comp.Name())
+					return err
+				}
+			}
+			add := &Component{
+				UsageLine: "add",
+				PreRun:    hook("add", "pre", nil),
+				PostRun:   hook("add", "post", nil),
+				Run: func(context.Context, *Component, []string) {
+					calls = append(calls, "add run")
+				},
+			}
+			root := &Component{
+				UsageLine: "tool",
+				Run:       Passthrough,
+				PreRun:    hook("tool", "pre", nil),
+				PostRun:   hook("tool", "post", nil),
+				Components: []*Component{
+					&Component{
+						UsageLine:  "remote",
+						PreRun:     hook("remote", "pre", tt.preRunErr),
+						PostRun:    hook("remote", "post", nil),
+						Components: []*Component{add},
+					},
+				},
+			}
+			var buf bytes.Buffer
+			root.SetOutput(&buf)
+
+			err := Execute(context.Background(), root, []string{"remote", "add"})
+			if err != tt.preRunErr {
+				t.Errorf("Execute() = %v, want %v", err, tt.preRunErr)
+			}
+			if !reflect.DeepEqual(calls, tt.want) {
+				t.Errorf("calls = %v, want %v", calls, tt.want)
+			}
+		})
+	}
+}
+
+func TestComponent_Precondition(t *testing.T) {
+	errNoCgo := errors.New("build requires cgo")
+	for _, tt := range []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"Satisfied", nil, true},
+		{"Failed", errNoCgo, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var ran bool
+			root := &Component{
+				UsageLine: "tool",
+				Run:       Passthrough,
+				Components: []*Component{
+					&Component{
+						UsageLine:    "build",
+						Precondition: func() error { return tt.err },
+						Run: func(context.Context, *Component, []string) {
+							ran = true
+						},
+					},
+				},
+			}
+
+			err := Execute(context.Background(), root, []string{"build"})
+			if err != tt.err {
+				t.Errorf("Execute() = %v, want %v", err, tt.err)
+			}
+			if ran != tt.want {
+				t.Errorf("ran = %v, want %v", ran, tt.want)
+			}
+		})
+	}
+}
+
+func TestComponent_Usage_GlobalFlags(t *testing.T) {
+	add := &Component{
+		UsageLine: "add",
+		Run:       func(context.Context, *Component, []string) {},
+	}
+	add.FlagSet().Bool("f", false, "force")
+	remote := &Component{
+		UsageLine:  "remote",
+		Components: []*Component{add},
+	}
+	remote.PersistentFlagSet().String("region", "eu", "region of the remote")
+	root := &Component{
+		UsageLine:  "tool",
+		Run:        Passthrough,
+		Components: []*Component{remote},
+	}
+	root.PersistentFlagSet().Bool("v", false, "verbose output")
+
+	root.Run(context.Background(), root, []string{"-v", "remote", "add"})
+
+	var buf bytes.Buffer
+	add.SetOutput(&buf)
+	add.Usage()
+
+	want := `Usage: tool remote add
+
+The flags are:
+  -f	force
+
+Global flags from tool remote:
+  -region string
+    	region of the remote (default "eu")
+
+Global flags from tool:
+  -v	verbose output
+`
+	if got := buf.String(); got != want {
+		t.Errorf("Component.Usage() = %v, want %v", got, want)
+	}
+}
+
+func TestArgsValidators(t *testing.T) {
+	args := func(n int) []string {
+		return make([]string, n)
+	}
+	tests := []struct {
+		name  string
+		valid func(c *Component, args []string) error
+		args  []string
+		want  string
+	}{
+		{"ExactArgs n", ExactArgs(2), args(2), ""},
+		{"ExactArgs n-1", ExactArgs(2), args(1), "cp accepts 2 arg(s), received 1"},
+		{"ExactArgs n+1", ExactArgs(2), args(3), "cp accepts 2 arg(s), received 3"},
+		{"MinimumArgs n", MinimumArgs(2), args(2), ""},
+		{"MinimumArgs n-1", MinimumArgs(2), args(1), "cp requires at least 2 arg(s), received 1"},
+		{"MinimumArgs n+1", MinimumArgs(2), args(3), ""},
+		{"MaximumArgs n", MaximumArgs(2), args(2), ""},
+		{"MaximumArgs n-1", MaximumArgs(2), args(1), ""},
+		{"MaximumArgs n+1", MaximumArgs(2), args(3), "cp accepts at most 2 arg(s), received 3"},
+		{"RangeArgs min", RangeArgs(1, 3), args(1), ""},
+		{"RangeArgs min-1", RangeArgs(1, 3), args(0), "cp accepts between 1 and 3 arg(s), received 0"},
+		{"RangeArgs max", RangeArgs(1, 3), args(3), ""},
+		{"RangeArgs max+1", RangeArgs(1, 3), args(4), "cp accepts between 1 and 3 arg(s), received 4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.valid(&Component{UsageLine: "cp"}, tt.args)
+			if "" == tt.want {
+				if nil != err {
+					t.Errorf("validator = %v, want nil", err)
+				}
+				return
+			}
+			if nil == err || err.Error() != tt.want {
+				t.Errorf("validator = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestComponent_AllowFlagAbbreviation(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		args    []string
+		verbose bool
+		output  string
+		err     string
+	}{
+		{"Unique Prefix", []string{"--verb", "--out=x", "a"}, true, "x", ""},
+		{"Exact Name", []string{"-verbose=false", "-output", "y"}, false, "y", ""},
+		{"Ambiguous Prefix", []string{"-ver"}, false, "",
+			"ambiguous flag -ver: could be -verbose, -version"},
+		{"No Match", []string{"-quiet"}, false, "",
+			"flag provided but not defined: -quiet"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Component{
+				UsageLine:             "test",
+				ErrorHandling:         ContinueOnError,
+				AllowFlagAbbreviation: true,
+			}
+			verbose := c.FlagSet().Bool("verbose", false, "verbose output")
+			c.FlagSet().Bool("version", false, "print the version")
+			output := c.FlagSet().String("output", "", "output file")
+			c.SetOutput(ioutil.Discard)
+
+			err := c.Parse(tt.args)
+			if "" != tt.err {
+				if nil == err || err.Error() != tt.err {
+					t.Errorf("Component.Parse() = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if nil != err {
+				t.Fatalf("Component.Parse() = %v, want nil", err)
+			}
+			if *verbose != tt.verbose || *output != tt.output {
+				t.Errorf("verbose, output = %v, %v, want %v, %v", *verbose,
+					*output, tt.verbose, tt.output)
+			}
+		})
+	}
+}
+
+func TestExecute_PrintCommand(t *testing.T) {
+	var ran bool
+	add := &Component{
+		UsageLine: "add",
+		Run: func(context.Context, *Component, []string) {
+			ran = true
+		},
+	}
+	add.FlagSet().Bool("f", false, "force")
+	remote := &Component{
+		UsageLine:  "remote",
+		Components: []*Component{add},
+	}
+	remote.PersistentFlagSet().String("region", "eu", "region of the remote")
+	root := &Component{
+		UsageLine:  "tool",
+		Run:        Passthrough,
+		Components: []*Component{remote},
+	}
+	root.FlagSet().Bool("v", false, "verbose output")
+
+	// The flag is added even if EnablePrintCommand is set once the flags
+	// exist
+	root.EnablePrintCommand = true
+
+	var buf bytes.Buffer
+	err := Execute(WithOutput(context.Background(), &buf), root,
+		[]string{"-print-command", "remote", "add", "-f", "origin"})
+	if nil != err {
+		t.Fatalf("Execute() = %v, want nil", err)
+	}
+	if ran {
+		t.Error("add ran, want it not to")
+	}
+
+	want := `tool remote add origin
+  -f=true
+  -region=eu
+  -v=false
+`
+	if got := buf.String(); got != want {
+		t.Errorf("output = %v, want %v", got, want)
+	}
+}
+
+func TestExecute_Cancelled(t *testing.T) {
+	tests := []struct {
+		name         string
+		cancelBefore bool
+		cancelPreRun bool
+	}{
+		{name: "Before Dispatch", cancelBefore: true},
+		{name: "During PreRun", cancelPreRun: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancelBefore {
+				cancel()
+			}
+
+			var ran bool
+			root := &Component{
+				UsageLine: "tool",
+				Run:       Passthrough,
+				PreRun: func(context.Context, *Component, []string) error {
+					if tt.cancelPreRun {
+						cancel()
+					}
+					return nil
+				},
+				Components: []*Component{
+					&Component{
+						UsageLine: "remote",
+						Components: []*Component{
+							&Component{
+								UsageLine: "add",
+								Run: func(context.Context, *Component, []string) {
+									ran = true
+								},
+							},
+						},
+					},
+				},
+			}
+
+			err := Execute(ctx, root, []string{"remote", "add"})
+			if err != context.Canceled {
+				t.Errorf("Execute() = %v, want %v", err, context.Canceled)
+			}
+			if ran {
+				t.Error("add ran, want it not to")
+			}
+		})
+	}
+}
+
+func TestRunMain(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		want   int
+		output string
+	}{
+		{name: "Success", args: []string{"build"}, want: 0},
+		{name: "Help", args: []string{"-help"}, want: 0, output: "Usage: tool"},
+		{name: "No Command", want: 2, output: "Usage: tool"},
+		{name: "Unknown Command", args: []string{"biuld"}, want: 2,
+			output: "unknown command: biuld\nDid you mean: build?\nUsage: tool"},
+		{name: "Invalid Flag", args: []string{"-x", "build"}, want: 2,
+			output: "flag provided but not defined: -x"},
+		{name: "Error", args: []string{"deploy"}, want: 1,
+			output: "deploy requires cloud credentials\n"},
+		{name: "Nested Success", args: []string{"remote", "add"}, want: 0},
+		{name: "Unknown Nested Command", args: []string{"remote", "bogus"},
+			want: 2, output: "unknown command: bogus\nUsage: tool remote"},
+		{name: "Invalid Flag Value", args: []string{"-port", "0", "build"},
+			want: 2, output: "port must be positive\nUsage: tool"},
+		{name: "Ambiguous Flag", args: []string{"-ver", "build"}, want: 2,
+			output: "ambiguous flag -ver: could be -verbose, -version\nUsage: tool"},
+		{name: "Invalid Arguments", args: []string{"serve"}, want: 2,
+			output: "serve accepts 1 arg(s), received 0\nUsage: tool serve"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			run := func(context.Context, *Component, []string) {}
+			root := &Component{
+				UsageLine:             "tool",
+				ErrorHandling:         ContinueOnError,
+				AllowFlagAbbreviation: true,
+				Run:                   Passthrough,
+				Components: []*Component{
+					&Component{UsageLine: "build", Run: run},
+					&Component{
+						UsageLine: "deploy",
+						Run:       run,
+						Precondition: func() error {
+							return errors.New("deploy requires cloud credentials")
+						},
+					},
+					&Component{
+						UsageLine:  "remote",
+						Run:        Passthrough,
+						Components: []*Component{&Component{UsageLine: "add", Run: run}},
+					},
+					&Component{UsageLine: "serve", Args: ExactArgs(1), Run: run},
+				},
+			}
+			root.FlagSet().Bool("verbose", false, "verbose output")
+			root.FlagSet().Bool("version", false, "print the version")
+			root.FlagSet().Int("port", 8080, "port to listen on")
+			root.AddFlagValidator("port", func(value string) error {
+				if "0" == value {
+					return errors.New("port must be positive")
+				}
+				return nil
+			})
+			var buf bytes.Buffer
+			root.SetOutput(&buf)
+
+			if got := RunMain(root, tt.args); got != tt.want {
+				t.Errorf("RunMain() = %v, want %v", got, tt.want)
+			}
+			if got := buf.String(); !strings.Contains(got, tt.output) {
+				t.Errorf("output = %v, want %v", got, tt.output)
+			}
+		})
+	}
+}
+
+func TestExecute_Help(t *testing.T) {
+	c := &Component{
+		UsageLine:     "tool",
+		ErrorHandling: ContinueOnError,
+		Run:           Passthrough,
+		Components: []*Component{
+			&Component{
+				UsageLine: "sync",
+				Short:     "sync the remotes",
+				Run:       func(context.Context, *Component, []string) {},
+			},
+		},
+	}
+	var buf bytes.Buffer
+	c.SetOutput(&buf)
+
+	if err := Execute(context.Background(), c, []string{"--help"}); err != ErrHelp {
+		t.Errorf("Execute() = %v, want %v", err, ErrHelp)
+	}
+	want := `
+The components are:
+  sync        sync the remotes
+`
+	if got := buf.String(); !strings.Contains(got, want) {
+		t.Errorf("output = %v, want to contain %v", got, want)
+	}
+}
+
+func TestExecute_Args(t *testing.T) {
+	var ran bool
+	cp := &Component{
+		UsageLine: "cp",
+		Args:      ExactArgs(2),
+		Run: func(context.Context, *Component, []string) {
+			ran = true
+		},
+	}
+	cp.FlagSet().Bool("r", false, "copy recursively")
+	c := &Component{
+		UsageLine:  UsageLine,
+		Run:        Passthrough,
+		Components: []*Component{cp},
+	}
+	var buf bytes.Buffer
+	c.SetOutput(&buf)
+
+	err := Execute(context.Background(), c, []string{"cp", "-r", "src"})
+	if want := "cp accepts 2 arg(s), received 1"; nil == err || err.Error() != want {
+		t.Errorf("Execute() = %v, want %v", err, want)
+	}
+	if ran {
+		t.Error("cp ran, want it not to")
+	}
+
+	c.Run(context.Background(), c, []string{"cp", "-r", "src"})
+	if got := buf.String(); !strings.HasPrefix(got,
+		"cp accepts 2 arg(s), received 1\nUsage: test cp") {
+		t.Errorf("output = %v, want the error followed by the usage of cp", got)
+	}
+
+	if err := Execute(context.Background(), c, []string{"cp", "-r", "src", "dst"}); nil != err || !ran {
+		t.Errorf("Execute() = %v, ran = %v, want nil, true", err, ran)
+	}
+}
+
+// tagsValue is a flag.Value appending each occurrence of the flag
+type tagsValue []string
+
+func (v *tagsValue) String() string {
+	return strings.Join(*v, ",")
+}
+
+func (v *tagsValue) Set(s string) error {
+	*v = append(*v, s)
+	return nil
+}
+
+func TestExecute_Args_ParsedOnce(t *testing.T) {
+	var tags tagsValue
+	var gotArgs []string
+	tag := &Component{
+		UsageLine: "tag",
+		Args:      ExactArgs(1),
+		Run: func(ctx context.Context, comp *Component, args []string) {
+			// Parsing again, as components without Args do, finds no flags
+			comp.FlagSet().Parse(args)
+			gotArgs = comp.FlagSet().Args()
+		},
+	}
+	tag.FlagSet().Var(&tags, "t", "add a tag")
+	c := &Component{
+		UsageLine:     UsageLine,
+		ErrorHandling: ContinueOnError,
+		Components:    []*Component{tag},
+	}
+
+	err := Execute(context.Background(), c, []string{"tag", "-t", "a", "v1"})
+	if nil != err {
+		t.Fatalf("Execute() error = %v, want nil", err)
+	}
+	if want := (tagsValue{"a"}); !reflect.DeepEqual(tags, want) {
+		t.Errorf("tags = %v, want %v", tags, want)
+	}
+	if want := []string{"v1"}; !reflect.DeepEqual(gotArgs, want) {
+		t.Errorf("args = %v, want %v", gotArgs, want)
+	}
+}
+
+func TestComponent_Router(t *testing.T) {
+	var got string
+	show := &Component{
+		UsageLine: "show",
+		Run: func(ctx context.Context, comp *Component, args []string) {
+			got = "show " + strings.Join(args, " ")
+		},
+	}
+	list := &Component{
+		UsageLine: "list",
+		Run: func(ctx context.Context, comp *Component, args []string) {
+			got = "list " + strings.Join(args, " ")
+		},
+	}
+	issue := regexp.MustCompile(`^#(\d+)$`)
+	c := &Component{
+		UsageLine:  "tool",
+		Run:        Passthrough,
+		Components: []*Component{show, list},
+		Router: func(c *Component, args []string) (*Component, []string, error) {
+			if m := issue.FindStringSubmatch(args[0]); nil != m {
+				return show, append([]string{m[1]}, args[1:]...), nil
+			}
+			return nil, nil, nil
+		},
+	}
+
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"#42", "-v"}, "show 42 -v"},
+		{[]string{"list", "open"}, "list open"},
+	} {
+		got = ""
+		if err := Execute(context.Background(), c, tt.args); nil != err {
+			t.Errorf("Execute(%v) = %v, want nil", tt.args, err)
+		}
+		if got != tt.want {
+			t.Errorf("Execute(%v) ran %v, want %v", tt.args, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,444 @@
+// Copyright (c) 2017 Qian Qiao
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"text/template"
+)
+
+// installHelpFormat is the format of the completion install instructions,
+// taking the program name, the shell, the command loading the completions in
+// the current session and the path of the completion file
+const installHelpFormat = `To load completions in the current session:
+
+  %[3]s
+
+To load completions for every new session:
+
+  %[1]s completion %[2]s > %[4]s
+`
+
+// CompletionInstallHelp returns instructions to install the completion script
+// of the running program for shell ("bash", "zsh" or "fish") on goos, the
+// operating system as reported by runtime.GOOS. It returns an empty string for
+// unsupported shells.
+//
+// The instructions run the completion component of the program, as returned
+// by CompletionComponent for its root
+func CompletionInstallHelp(shell, goos string) string {
+	name := programName()
+
+	var load, path, note string
+	switch shell {
+	case "bash":
+		load = fmt.Sprintf("source <(%s completion bash)", name)
+		path = "/etc/bash_completion.d/" + name
+		if "darwin" == goos {
+			path = "$(brew --prefix)/etc/bash_completion.d/" + name
+			note = "\nThis requires the bash-completion package from Homebrew.\n"
+		}
+	case "zsh":
+		load = fmt.Sprintf("source <(%s completion zsh)", name)
+		path = "/usr/local/share/zsh/site-functions/_" + name
+		if "darwin" == goos {
+			path = "$(brew --prefix)/share/zsh/site-functions/_" + name
+		}
+	case "fish":
+		load = fmt.Sprintf("%s completion fish | source", name)
+		path = "~/.config/fish/completions/" + name + ".fish"
+	default:
+		return ""
+	}
+
+	return fmt.Sprintf(installHelpFormat, name, shell, load, path) + note
+}
+
+// completionShells are the shells supported by CompletionComponent, with the
+// generators of their completion scripts
+var completionShells = []struct {
+	name string
+	gen  func(c *Component, w io.Writer) error
+}{
+	{"bash", (*Component).GenBashCompletion},
+	{"zsh", (*Component).GenZshCompletion},
+	{"fish", (*Component).GenFishCompletion},
+}
+
+// CompletionComponent returns a completion component for root. Run with the
+// name of a shell, e.g. "completion bash", it writes the completion script of
+// root for that shell to the output in the context, as set by WithOutput.
+// Its usage information includes the install instructions of each shell for
+// the running operating system, as returned by CompletionInstallHelp
+func CompletionComponent(root *Component) *Component {
+	shell := func(name string) func(*Component, io.Writer) error {
+		for _, s := range completionShells {
+			if name == s.name {
+				return s.gen
+			}
+		}
+		return nil
+	}
+
+	names := make([]string, len(completionShells))
+	long := "Prints the completion script for the shell."
+	for i, s := range completionShells {
+		names[i] = s.name
+		long += fmt.Sprintf("\n\nFor %s:\n\n%s", s.name, strings.TrimSpace(
+			CompletionInstallHelp(s.name, runtime.GOOS)))
+	}
+
+	return &Component{
+		UsageLine: "completion " + strings.Join(names, "|"),
+		Short:     "print the completion script for a shell",
+		Long:      long,
+		Args: func(c *Component, args []string) error {
+			if err := ExactArgs(1)(c, args); nil != err {
+				return err
+			}
+			if nil == shell(args[0]) {
+				return fmt.Errorf("unsupported shell: %s", args[0])
+			}
+			return nil
+		},
+		Run: func(ctx context.Context, comp *Component, args []string) {
+			gen := shell(comp.FlagSet().Arg(0))
+			if err := gen(root, OutputFromContext(ctx)); nil != err {
+				fmt.Fprintln(comp.FlagSet().Output(), err)
+			}
+		},
+	}
+}
+
+// completionCommand is a component as completed by the completion scripts
+type completionCommand struct {
+	// Path is the path of the component from the root
+	Path string
+
+	// Seen are the names and aliases of each component on the path below
+	// the root, as seen on the command line
+	Seen [][]string
+
+	// Flags are the flags of the component, sorted by name
+	Flags []*flag.Flag
+
+	// Subcommands are the enabled sub-components
+	Subcommands []completionSubcommand
+}
+
+// completionSubcommand is a sub-component as completed by the completion
+// scripts
+type completionSubcommand struct {
+	// Names are the name and the aliases of the sub-component
+	Names []string
+
+	// Short is the short description of the sub-component
+	Short string
+
+	// Path is the path of the sub-component from the root
+	Path string
+}
+
+// Names returns the names and aliases of the sub-components
+func (c completionCommand) Names() []string {
+	names := []string{}
+	for _, sub := range c.Subcommands {
+		names = append(names, sub.Names...)
+	}
+	return names
+}
+
+// FlagNames returns the names of the flags, with their leading dash
+func (c completionCommand) FlagNames() []string {
+	names := []string{}
+	for _, f := range c.Flags {
+		names = append(names, "-"+f.Name)
+	}
+	return names
+}
+
+// completionCommands returns the component and all of its enabled
+// descendants as completed by the completion scripts, parents first
+func (c *Component) completionCommands() []completionCommand {
+	flags := make(map[string][]*flag.Flag)
+	c.allFlags(flags, c.Name(), nil)
+	return c.appendCompletionCommands(nil, c.Name(), nil, flags)
+}
+
+func (c *Component) appendCompletionCommands(commands []completionCommand,
+	path string, seen [][]string,
+	flags map[string][]*flag.Flag) []completionCommand {
+	command := completionCommand{Path: path, Seen: seen, Flags: flags[path]}
+
+	var children []*Component
+	for _, child := range c.Components {
+		if !child.Enabled() || (!child.Runnable() && 0 == len(child.Components)) {
+			continue
+		}
+		children = append(children, child)
+		command.Subcommands = append(command.Subcommands, completionSubcommand{
+			Names: append([]string{child.Name()}, child.Aliases...),
+			Short: child.Short,
+			Path:  path + " " + child.Name(),
+		})
+	}
+
+	commands = append(commands, command)
+	for i, child := range children {
+		childSeen := append(append([][]string(nil), seen...),
+			command.Subcommands[i].Names)
+		commands = child.appendCompletionCommands(commands,
+			path+" "+child.Name(), childSeen, flags)
+	}
+	return commands
+}
+
+// bashCompletionTemplate is the template of the bash completion script. The
+// path of the component being completed is found by following the words on
+// the command line from the root, skipping the flags and their values
+var bashCompletionTemplate = `# bash completion for {{.name}}
+
+{{function .name}}() {
+    local cur word path commands flags
+    cur="${COMP_WORDS[COMP_CWORD]}"
+    path="{{.name}}"
+    for word in "${COMP_WORDS[@]:1:COMP_CWORD-1}"; do
+        case "${path} ${word}" in
+{{- range .commands}}{{$parent := .Path}}{{range .Subcommands}}{{$path := .Path}}{{range .Names}}
+            "{{$parent}} {{.}}") path="{{$path}}" ;;
+{{- end}}{{end}}{{end}}
+        esac
+    done
+
+    case "${path}" in
+{{- range .commands}}
+        "{{.Path}}")
+            commands="{{join .Names " "}}"
+            flags="{{join .FlagNames " "}}"
+            ;;
+{{- end}}
+    esac
+
+    if [[ "${cur}" == -* ]]; then
+        COMPREPLY=($(compgen -W "${flags}" -- "${cur}"))
+    else
+        COMPREPLY=($(compgen -W "${commands}" -- "${cur}"))
+    fi
+}
+
+complete -F {{function .name}} {{.name}}
+`
+
+// zshCompletionTemplate is the template of the zsh completion script, with
+// one function per component dispatching to the functions of its
+// sub-components
+var zshCompletionTemplate = `#compdef {{.name}}
+
+# zsh completion for {{.name}}
+{{range .commands}}
+{{function .Path}}() {
+{{- if .Subcommands}}
+    local context state state_descr line
+    typeset -A opt_args
+
+    _arguments -C \
+{{- range .Flags}}
+        {{zshFlag .}} \
+{{- end}}
+        '1: :->command' \
+        '*:: :->args'
+
+    case $state in
+        command)
+            local -a commands
+            commands=(
+{{- range .Subcommands}}{{$short := .Short}}{{range .Names}}
+                {{zshCommand . $short}}
+{{- end}}{{end}}
+            )
+            _describe -t commands '{{.Path}} commands' commands
+            ;;
+        args)
+            case $line[1] in
+{{- range .Subcommands}}
+                {{join .Names "|"}}) {{function .Path}} ;;
+{{- end}}
+            esac
+            ;;
+    esac
+{{- else}}
+    _arguments \
+{{- range .Flags}}
+        {{zshFlag .}} \
+{{- end}}
+        '*: :_default'
+{{- end}}
+}
+{{end}}
+if [ "$funcstack[1]" = "{{function .name}}" ]; then
+    {{function .name}} "$@"
+else
+    compdef {{function .name}} {{.name}}
+fi
+`
+
+// GenZshCompletion writes a zsh completion script for the component and all
+// of its enabled descendants to w. The script completes the names and
+// aliases of the sub-components with their Short descriptions, and the flags
+// with their usage as printed by flag.PrintDefaults
+func (c *Component) GenZshCompletion(w io.Writer) error {
+	return genCompletion(w, zshCompletionTemplate, map[string]interface{}{
+		"name":     c.Name(),
+		"commands": c.completionCommands(),
+	})
+}
+
+// zshFlag returns the quoted zsh _arguments specification of f
+func zshFlag(f *flag.Flag) string {
+	name, usage := flag.UnquoteUsage(f)
+	spec := fmt.Sprintf("-%s[%s]", f.Name, zshEscape(usage, "[]"))
+	if !isBoolFlag(f) {
+		spec += fmt.Sprintf(":%s:", zshEscape(name, ":"))
+	}
+	return zshQuote(spec)
+}
+
+// zshCommand returns the quoted zsh _describe entry of the named command
+func zshCommand(name, short string) string {
+	if "" == short {
+		return zshQuote(zshEscape(name, ":"))
+	}
+	return zshQuote(zshEscape(name, ":") + ":" + short)
+}
+
+// zshEscape escapes the characters in chars in s with backslashes
+func zshEscape(s, chars string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(chars, r) || '\\' == r {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// zshQuote returns s in single quotes
+func zshQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// fishCompletionTemplate is the template of the fish completion script, with
+// the sub-components and flags of each component completed once its path
+// has been seen on the command line
+var fishCompletionTemplate = `# fish completion for {{.name}}
+{{range .commands}}
+# {{.Path}}
+{{- $condition := fishCondition .}}
+{{- range .Subcommands}}{{$short := .Short}}{{range .Names}}
+complete -c {{$.name}} -f -n {{$condition}} -a {{fishQuote .}}
+{{- with $short}} -d {{fishQuote .}}{{end}}
+{{- end}}{{end}}
+{{- range .Flags}}
+complete -c {{$.name}} -n {{$condition}} -o {{.Name}}
+{{- if not (isBoolFlag .)}} -r{{end}}
+{{- with flagUsage .}} -d {{fishQuote .}}{{end}}
+{{- end}}
+{{end}}`
+
+// GenFishCompletion writes a fish completion script for the component and
+// all of its enabled descendants to w. The script completes the names and
+// aliases of the sub-components, including the ones that are not runnable,
+// and the flags, with their descriptions
+func (c *Component) GenFishCompletion(w io.Writer) error {
+	return genCompletion(w, fishCompletionTemplate, map[string]interface{}{
+		"name":     c.Name(),
+		"commands": c.completionCommands(),
+	})
+}
+
+// fishCondition returns the quoted fish condition under which the
+// sub-components and flags of command are completed: the components on its
+// path have been seen, but none of its sub-components
+func fishCondition(command completionCommand) string {
+	var conditions []string
+	for _, names := range command.Seen {
+		conditions = append(conditions,
+			"__fish_seen_subcommand_from "+strings.Join(names, " "))
+	}
+	if names := command.Names(); 0 != len(names) {
+		conditions = append(conditions,
+			"not __fish_seen_subcommand_from "+strings.Join(names, " "))
+	}
+	if 0 == len(conditions) {
+		return "true"
+	}
+	return fishQuote(strings.Join(conditions, "; and "))
+}
+
+// flagUsage returns the usage of f as printed by flag.PrintDefaults
+func flagUsage(f *flag.Flag) string {
+	_, usage := flag.UnquoteUsage(f)
+	return usage
+}
+
+// fishQuote returns s in single quotes
+func fishQuote(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	return "'" + strings.Replace(s, "'", `\'`, -1) + "'"
+}
+
+// GenBashCompletion writes a bash completion script for the component and
+// all of its enabled descendants to w. The script completes the names and
+// aliases of the sub-components, and the flags after a "-"
+func (c *Component) GenBashCompletion(w io.Writer) error {
+	return genCompletion(w, bashCompletionTemplate, map[string]interface{}{
+		"name":     c.Name(),
+		"commands": c.completionCommands(),
+	})
+}
+
+// completionFunction returns the name of the completion function of the
+// component at path: the path prefixed by an underscore, with the characters
+// not allowed in the names of shell functions replaced by underscores
+func completionFunction(path string) string {
+	return "_" + strings.Map(func(r rune) rune {
+		if 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' ||
+			'0' <= r && r <= '9' {
+			return r
+		}
+		return '_'
+	}, path)
+}
+
+// genCompletion renders the completion script template text with data to w
+func genCompletion(w io.Writer, text string, data interface{}) error {
+	t := template.New("completion").Funcs(template.FuncMap{
+		"join":          strings.Join,
+		"function":      completionFunction,
+		"zshFlag":       zshFlag,
+		"zshCommand":    zshCommand,
+		"fishQuote":     fishQuote,
+		"fishCondition": fishCondition,
+		"isBoolFlag":    isBoolFlag,
+		"flagUsage":     flagUsage,
+	})
+	return template.Must(t.Parse(text)).Execute(w, data)
+}
@@ -0,0 +1,205 @@
+// Copyright (c) 2017 Qian Qiao
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// completionTree returns a small two level tree for the completion tests
+func completionTree() *Component {
+	run := func(context.Context, *Component, []string) {}
+	add := &Component{UsageLine: "add", Run: run}
+	add.FlagSet().Bool("f", false, "force")
+	remote := &Component{
+		UsageLine: "remote",
+		Aliases:   []string{"r"},
+		Components: []*Component{
+			add,
+			&Component{UsageLine: "rm", Run: run},
+		},
+	}
+	remote.PersistentFlagSet().String("region", "eu", "region of the remote")
+	root := &Component{
+		UsageLine: "tool",
+		Run:       Passthrough,
+		Components: []*Component{
+			remote,
+			&Component{UsageLine: "version", Run: run},
+		},
+	}
+	root.FlagSet().Bool("v", false, "verbose output")
+	return root
+}
+
+// checkGolden compares got with the golden file testdata/name
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	want, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if nil != err {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("output = %s, want %s", got, want)
+	}
+}
+
+func TestCompletionInstallHelp(t *testing.T) {
+	defer func(f func() string) { programName = f }(programName)
+	programName = func() string { return "tool" }
+
+	linux := CompletionInstallHelp("bash", "linux")
+	darwin := CompletionInstallHelp("bash", "darwin")
+
+	if !strings.Contains(linux, "tool completion bash > /etc/bash_completion.d/tool") {
+		t.Errorf("CompletionInstallHelp(bash, linux) = %v, want the system path", linux)
+	}
+	if !strings.Contains(darwin, "$(brew --prefix)/etc/bash_completion.d/tool") {
+		t.Errorf("CompletionInstallHelp(bash, darwin) = %v, want the Homebrew path", darwin)
+	}
+	if linux == darwin {
+		t.Errorf("CompletionInstallHelp(bash) = %v for both linux and darwin", linux)
+	}
+
+	for _, shell := range []string{"zsh", "fish"} {
+		if got := CompletionInstallHelp(shell, "linux"); !strings.Contains(got,
+			"tool completion "+shell) {
+			t.Errorf("CompletionInstallHelp(%v, linux) = %v", shell, got)
+		}
+	}
+	if got := CompletionInstallHelp("powershell", "windows"); "" != got {
+		t.Errorf("CompletionInstallHelp(powershell, windows) = %v, want none", got)
+	}
+}
+
+func TestCompletionComponent(t *testing.T) {
+	root := completionTree()
+	root.ErrorHandling = ContinueOnError
+	completion := CompletionComponent(root)
+	root.Components = append(root.Components, completion)
+
+	if !strings.Contains(completion.Long, "completion fish | source") {
+		t.Errorf("Long = %v, want the install instructions", completion.Long)
+	}
+
+	tests := []struct {
+		name    string
+		args    []string
+		gen     func(c *Component, w io.Writer) error
+		wantErr string
+	}{
+		{
+			name: "Bash",
+			args: []string{"completion", "bash"},
+			gen:  (*Component).GenBashCompletion,
+		},
+		{
+			name: "Zsh",
+			args: []string{"completion", "zsh"},
+			gen:  (*Component).GenZshCompletion,
+		},
+		{
+			name: "Fish",
+			args: []string{"completion", "fish"},
+			gen:  (*Component).GenFishCompletion,
+		},
+		{
+			name:    "Unsupported Shell",
+			args:    []string{"completion", "powershell"},
+			wantErr: "unsupported shell: powershell",
+		},
+		{
+			name:    "No Shell",
+			args:    []string{"completion"},
+			wantErr: "completion accepts 1 arg(s), received 0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			ctx := WithOutput(context.Background(), &buf)
+			err := Execute(ctx, root, tt.args)
+			if "" != tt.wantErr {
+				if nil == err || err.Error() != tt.wantErr {
+					t.Errorf("Execute() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if nil != err {
+				t.Fatalf("Execute() error = %v, want nil", err)
+			}
+
+			var want bytes.Buffer
+			if err := tt.gen(root, &want); nil != err {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != want.String() {
+				t.Errorf("output = %v, want %v", got, want.String())
+			}
+		})
+	}
+}
+
+func TestComponent_GenBashCompletion(t *testing.T) {
+	var buf bytes.Buffer
+	if err := completionTree().GenBashCompletion(&buf); nil != err {
+		t.Fatalf("Component.GenBashCompletion() = %v, want nil", err)
+	}
+	checkGolden(t, "bash_completion.golden", buf.Bytes())
+}
+
+func TestComponent_GenZshCompletion(t *testing.T) {
+	c := completionTree()
+	c.FlagSet().String("out", "", "write the output to `file`")
+
+	var buf bytes.Buffer
+	if err := c.GenZshCompletion(&buf); nil != err {
+		t.Fatalf("Component.GenZshCompletion() = %v, want nil", err)
+	}
+	got := buf.String()
+	if !strings.HasPrefix(got, "#compdef tool\n") {
+		t.Errorf("Component.GenZshCompletion() = %v, want #compdef tool", got)
+	}
+	for _, want := range []string{
+		"'remote'",
+		"'r'",
+		"'version'",
+		"remote|r) _tool_remote ;;",
+		"add) _tool_remote_add ;;",
+		"rm) _tool_remote_rm ;;",
+		"'-v[verbose output]'",
+		"'-out[write the output to file]:file:'",
+		"'-region[region of the remote]:string:'",
+		"'-f[force]'",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Component.GenZshCompletion() = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestComponent_GenFishCompletion(t *testing.T) {
+	var buf bytes.Buffer
+	if err := completionTree().GenFishCompletion(&buf); nil != err {
+		t.Fatalf("Component.GenFishCompletion() = %v, want nil", err)
+	}
+	checkGolden(t, "fish_completion.golden", buf.Bytes())
+}
@@ -0,0 +1,179 @@
+// Copyright (c) 2017 Qian Qiao
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// manPageTemplate is the template of the man page of a component
+var manPageTemplate = `.TH "{{upper .page}}" "1"
+.SH NAME
+{{escape .page}}{{with .component.Short}} \- {{escape .}}{{end}}
+.SH SYNOPSIS
+.B {{escape .synopsis}}
+{{- with .description}}
+.SH DESCRIPTION
+{{escape .}}
+{{- end}}
+{{- with .flags}}
+.SH OPTIONS
+{{- range .}}
+.TP
+.B \-{{escape .Name}}{{with flagArg .}} \fI{{escape .}}\fR{{end}}
+{{escape (flagUsage .)}}{{with flagDefault .}} (default {{escape .}}){{end}}
+{{- end}}
+{{- end}}
+{{- with .seeAlso}}
+.SH SEE ALSO
+{{range $i, $page := .}}{{if $i}}, {{end}}\fB{{escape $page}}\fR(1){{end}}
+{{- end}}
+`
+
+// GenManPage writes the man page of the component to w, in roff. The page
+// describes the component with its UsageLine, Short and Long descriptions
+// and flags, and refers to the pages of its parent and sub-components
+func (c *Component) GenManPage(w io.Writer) error {
+	flags := make(map[string][]*flag.Flag)
+	c.allFlags(flags, c.FullName(), nil)
+	return c.genManPage(w, c.FullName(), flags[c.FullName()])
+}
+
+// GenManTree writes the man pages of the component and all of its enabled
+// descendants to dir, one file per component named after its path, e.g.
+// tool.1 and tool-remote.1
+func (c *Component) GenManTree(dir string) error {
+	flags := make(map[string][]*flag.Flag)
+	c.allFlags(flags, c.Name(), nil)
+	return c.genManTree(dir, c.Name(), flags)
+}
+
+func (c *Component) genManTree(dir, path string,
+	flags map[string][]*flag.Flag) error {
+	f, err := os.Create(filepath.Join(dir, manPage(path)+".1"))
+	if nil != err {
+		return err
+	}
+	if err := c.genManPage(f, path, flags[path]); nil != err {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); nil != err {
+		return err
+	}
+
+	for _, child := range c.Components {
+		if !child.Enabled() {
+			continue
+		}
+		if err := child.genManTree(dir, path+" "+child.Name(),
+			flags); nil != err {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Component) genManPage(w io.Writer, path string,
+	flags []*flag.Flag) error {
+
+	var seeAlso []string
+	if i := strings.LastIndex(path, " "); i >= 0 {
+		seeAlso = append(seeAlso, manPage(path[:i]))
+	}
+	for _, child := range c.Components {
+		if child.Enabled() {
+			seeAlso = append(seeAlso, manPage(path+" "+child.Name()))
+		}
+	}
+
+	t := template.New("man").Funcs(template.FuncMap{
+		"upper":       strings.ToUpper,
+		"escape":      manEscape,
+		"flagArg":     flagArg,
+		"flagUsage":   flagUsage,
+		"flagDefault": flagDefault,
+	})
+	return template.Must(t.Parse(manPageTemplate)).Execute(w,
+		map[string]interface{}{
+			"component":   c,
+			"page":        manPage(path),
+			"synopsis":    c.synopsis(path),
+			"description": c.description(),
+			"flags":       flags,
+			"seeAlso":     seeAlso,
+		})
+}
+
+// synopsis returns the command line of the component at path: the path of
+// its parent followed by its UsageLine and ArgNames
+func (c *Component) synopsis(path string) string {
+	synopsis := c.UsageLine
+	if i := strings.LastIndex(path, " "); i >= 0 {
+		synopsis = path[:i] + " " + synopsis
+	}
+	for _, name := range c.ArgNames {
+		synopsis += " <" + name + ">"
+	}
+	return synopsis
+}
+
+// description returns the Long description of the component, or its Short
+// description if it has none
+func (c *Component) description() string {
+	if description := strings.TrimSpace(c.Long); "" != description {
+		return description
+	}
+	return c.Short
+}
+
+// manPage returns the name of the man page of the component at path
+func manPage(path string) string {
+	return strings.Replace(path, " ", "-", -1)
+}
+
+// manEscape escapes the characters of s special to roff
+func manEscape(s string) string {
+	s = strings.Replace(s, `\`, `\e`, -1)
+	s = strings.Replace(s, "-", `\-`, -1)
+
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, ".") || strings.HasPrefix(line, "'") {
+			lines[i] = `\&` + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// flagArg returns the name of the argument of f, empty for boolean flags
+func flagArg(f *flag.Flag) string {
+	name, _ := flag.UnquoteUsage(f)
+	return name
+}
+
+// flagDefault returns the default value of f, empty if it is the zero value
+func flagDefault(f *flag.Flag) string {
+	switch f.DefValue {
+	case "", "0", "false":
+		return ""
+	}
+	return f.DefValue
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2017 Qian Qiao
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestComponent_GenManPage(t *testing.T) {
+	c := completionTree()
+	c.Short = "manage remotes"
+	c.Long = "Tool manages remotes.\n.Lines starting with a dot are text."
+	c.FlagSet().String("out", "", "write the output to `file`")
+
+	var buf bytes.Buffer
+	if err := c.GenManPage(&buf); nil != err {
+		t.Fatalf("Component.GenManPage() = %v, want nil", err)
+	}
+	got := buf.String()
+	for _, want := range []string{
+		".TH \"TOOL\" \"1\"\n",
+		".SH NAME\ntool \\- manage remotes\n",
+		".SH SYNOPSIS\n.B tool\n",
+		".SH DESCRIPTION\nTool manages remotes.\n\\&.Lines starting",
+		".B \\-out \\fIfile\\fR\nwrite the output to file\n",
+		".B \\-v\nverbose output\n",
+		".SH SEE ALSO\n\\fBtool\\-remote\\fR(1), \\fBtool\\-version\\fR(1)\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Component.GenManPage() = %v, want %q", got, want)
+		}
+	}
+}
+
+func TestComponent_GenManTree(t *testing.T) {
+	dir := t.TempDir()
+	if err := completionTree().GenManTree(dir); nil != err {
+		t.Fatalf("Component.GenManTree() = %v, want nil", err)
+	}
+
+	for _, name := range []string{
+		"tool.1",
+		"tool-remote.1",
+		"tool-remote-add.1",
+		"tool-remote-rm.1",
+		"tool-version.1",
+	} {
+		if _, err := os.Stat(filepath.Join(dir, name)); nil != err {
+			t.Errorf("Component.GenManTree() did not write %v: %v", name, err)
+		}
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "tool-remote-add.1"))
+	if nil != err {
+		t.Fatal(err)
+	}
+	if want := ".B \\-region \\fIstring\\fR\nregion of the remote (default eu)\n"; !strings.Contains(string(b), want) {
+		t.Errorf("tool-remote-add.1 = %s, want %q", b, want)
+	}
+}
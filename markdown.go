@@ -0,0 +1,134 @@
+// Copyright (c) 2017 Qian Qiao
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// markdownTemplate is the template of the Markdown page of a component
+var markdownTemplate = `# {{.path}}
+{{with .description}}
+{{.}}
+{{end}}
+{{fence}}
+{{.synopsis}}
+{{fence}}
+{{- with .flags}}
+
+## Options
+{{range .}}
+* {{code (flagSpec .)}}{{with flagUsage .}}: {{.}}{{end}}
+{{- with flagDefault .}} (default {{.}}){{end}}
+{{- end}}
+{{- end}}
+{{- with .commands}}
+
+## Commands
+{{range .}}
+* [{{.Path}}]({{markdownPage .Path}}){{with .Short}} - {{.}}{{end}}
+{{- end}}
+{{- end}}
+{{- with .parent}}
+
+## See also
+
+* [{{.}}]({{markdownPage .}})
+{{- end}}
+`
+
+// GenMarkdownTree writes the Markdown documentation of the component and all
+// of its enabled descendants to dir, one page per component named after its
+// path with spaces replaced by underscores, e.g. tool.md and tool_remote.md.
+// Each page links to the pages of its parent and sub-components
+func (c *Component) GenMarkdownTree(dir string) error {
+	flags := make(map[string][]*flag.Flag)
+	c.allFlags(flags, c.Name(), nil)
+	return c.genMarkdownTree(dir, c.Name(), flags)
+}
+
+func (c *Component) genMarkdownTree(dir, path string,
+	flags map[string][]*flag.Flag) error {
+	var commands []completionSubcommand
+	for _, child := range c.Components {
+		if child.Enabled() {
+			commands = append(commands, completionSubcommand{
+				Short: child.Short,
+				Path:  path + " " + child.Name(),
+			})
+		}
+	}
+	var parent string
+	if i := strings.LastIndex(path, " "); i >= 0 {
+		parent = path[:i]
+	}
+
+	f, err := os.Create(filepath.Join(dir, markdownPage(path)))
+	if nil != err {
+		return err
+	}
+	t := template.New("markdown").Funcs(template.FuncMap{
+		"markdownPage": markdownPage,
+		"fence":        func() string { return "```" },
+		"code":         func(s string) string { return "`" + s + "`" },
+		"flagSpec":     flagSpec,
+		"flagUsage":    flagUsage,
+		"flagDefault":  flagDefault,
+	})
+	err = template.Must(t.Parse(markdownTemplate)).Execute(f,
+		map[string]interface{}{
+			"path":        path,
+			"description": c.description(),
+			"synopsis":    c.synopsis(path),
+			"flags":       flags[path],
+			"commands":    commands,
+			"parent":      parent,
+		})
+	if closeErr := f.Close(); nil == err {
+		err = closeErr
+	}
+	if nil != err {
+		return err
+	}
+
+	for _, child := range c.Components {
+		if !child.Enabled() {
+			continue
+		}
+		if err := child.genMarkdownTree(dir, path+" "+child.Name(),
+			flags); nil != err {
+			return err
+		}
+	}
+	return nil
+}
+
+// markdownPage returns the name of the Markdown page of the component at path
+func markdownPage(path string) string {
+	return strings.Replace(path, " ", "_", -1) + ".md"
+}
+
+// flagSpec returns f as given on the command line, with the name of its
+// argument, if any
+func flagSpec(f *flag.Flag) string {
+	if arg := flagArg(f); "" != arg {
+		return "-" + f.Name + " " + arg
+	}
+	return "-" + f.Name
+}
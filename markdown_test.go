@@ -0,0 +1,79 @@
+// Copyright (c) 2017 Qian Qiao
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestComponent_GenMarkdownTree(t *testing.T) {
+	c := completionTree()
+	c.Long = "Tool manages remotes."
+	c.Components[0].Short = "manage remotes"
+
+	dir := t.TempDir()
+	if err := c.GenMarkdownTree(dir); nil != err {
+		t.Fatalf("Component.GenMarkdownTree() = %v, want nil", err)
+	}
+
+	tests := []struct {
+		page string
+		want []string
+	}{
+		{
+			page: "tool.md",
+			want: []string{
+				"# tool\n\nTool manages remotes.\n\n```\ntool\n```\n",
+				"## Options\n\n* `-v`: verbose output\n",
+				"## Commands\n\n* [tool remote](tool_remote.md) - manage remotes\n" +
+					"* [tool version](tool_version.md)\n",
+			},
+		},
+		{
+			page: "tool_remote.md",
+			want: []string{
+				"# tool remote\n\nmanage remotes\n",
+				"* [tool remote add](tool_remote_add.md)\n",
+				"## See also\n\n* [tool](tool.md)\n",
+			},
+		},
+		{
+			page: "tool_remote_add.md",
+			want: []string{
+				"# tool remote add\n\n```\ntool remote add\n```\n",
+				"* `-region string`: region of the remote (default eu)\n",
+				"## See also\n\n* [tool remote](tool_remote.md)\n",
+			},
+		},
+		{page: "tool_remote_rm.md", want: []string{"# tool remote rm\n"}},
+		{page: "tool_version.md", want: []string{"# tool version\n"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.page, func(t *testing.T) {
+			b, err := ioutil.ReadFile(filepath.Join(dir, tt.page))
+			if nil != err {
+				t.Fatal(err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(string(b), want) {
+					t.Errorf("%v = %s, want %q", tt.page, b, want)
+				}
+			}
+		})
+	}
+}